@@ -10,13 +10,12 @@ import (
 	"go.uber.org/dig"
 )
 
-// NewContainer returns a new DI container
-func NewContainer() *dig.Container {
+// NewContainer returns a new DI container for a loaded config
+func NewContainer(conf *config.Config) *dig.Container {
 	c := dig.New()
 
-	c.Provide(config.NewConfig)
+	c.Provide(func() *config.Config { return conf })
 	c.Provide(resp.NewPool)
-	c.Provide(resp.NewScanner)
 	c.Provide(resp.NewServer)
 	c.Provide(processor.NewProcessor)
 	c.Provide(db.NewManager)
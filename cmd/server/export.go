@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/furui/gochunk/pkg/config"
+	"github.com/furui/gochunk/pkg/db"
+	"github.com/furui/gochunk/pkg/world"
+)
+
+// exportLockTimeout is how long export waits for a running server to release the data directory
+const exportLockTimeout = time.Second
+
+// export dumps a world from a data directory to stdout without starting the server.
+// The files are opened read-only, it fails if the server is running against the same directory.
+func export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s export [options] world\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	index := flags.Int("db", 0, "database index")
+	format := flags.String("format", world.FormatJSON, "export format, json or geojson")
+	// The data directory and cache settings come from the same config as the server's
+	conf, err := config.LoadFlags(flags, args, os.Environ())
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	f, err := world.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+	d, err := db.OpenReadOnly(conf, *index, exportLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	out := bufio.NewWriter(os.Stdout)
+	if err := world.WriteExport(out, d, []byte(flags.Arg(0)), f); err != nil {
+		return err
+	}
+	return out.Flush()
+}
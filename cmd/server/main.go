@@ -1,35 +1,93 @@
 package main
 
 import (
-	"fmt"
+	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/furui/gochunk/pkg/config"
+	"github.com/furui/gochunk/pkg/db"
 	"github.com/furui/gochunk/pkg/resp"
+	"github.com/furui/gochunk/pkg/world"
 )
 
 func main() {
-	c := NewContainer()
-	log.Print("Starting server")
-	err := c.Invoke(func(s resp.Server) {
-		err := s.Start()
-		if err != nil {
-			panic(err)
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := export(os.Args[2:]); err != nil {
+			log.Fatal(err)
 		}
-	})
+		return
+	}
+	conf, err := config.Load(os.Args[1:], os.Environ())
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	c := NewContainer(conf)
+	err = c.Invoke(world.Register)
 	if err != nil {
 		panic(err)
 	}
-	log.Print("Server started")
-	var s string
-	fmt.Scanln(&s)
-	err = c.Invoke(func(s resp.Server) {
-		err := s.Stop()
+	log.Print("Starting server")
+	err = c.Invoke(func(s resp.Server, conf *config.Config, dbManager db.Manager) {
+		err := s.Start()
 		if err != nil {
 			panic(err)
 		}
+		log.Print("Server started")
+		run(s, conf, dbManager)
 	})
 	if err != nil {
 		panic(err)
 	}
 	log.Print("Server stopped")
 }
+
+// run serves until SIGINT, SIGTERM or a SHUTDOWN command, then drains the
+// connections and closes the databases
+func run(s resp.Server, conf *config.Config, dbManager db.Manager) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	opts := resp.ShutdownOptions{}
+wait:
+	for {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				if err := s.ReloadTLS(); err != nil {
+					log.Printf("couldn't reload TLS certificates: %s", err)
+					continue
+				}
+				log.Print("TLS certificates reloaded")
+				continue
+			}
+			log.Printf("Received %s, shutting down", sig)
+			break wait
+		case opts = <-s.ShutdownRequested():
+			log.Print("SHUTDOWN received, shutting down")
+			break wait
+		}
+	}
+
+	if opts.Now {
+		if err := s.Stop(); err != nil {
+			log.Printf("couldn't stop server: %s", err)
+		}
+	} else if err := s.Shutdown(conf.ShutdownTimeout); err != nil {
+		log.Printf("couldn't drain connections: %s", err)
+	}
+	if !opts.NoSave {
+		if err := dbManager.Save(); err != nil {
+			log.Printf("couldn't save databases: %s", err)
+		}
+	}
+	if err := dbManager.Close(); err != nil {
+		log.Printf("couldn't close databases: %s", err)
+	}
+}
@@ -1,12 +1,17 @@
 module github.com/furui/gochunk
 
+go 1.27
+
 require (
 	github.com/armon/go-radix v0.0.0-20180808171621-7fddfc383310
-	github.com/boltdb/bolt v1.3.1 // indirect
-	github.com/davecgh/go-spew v1.1.0 // indirect
 	github.com/etcd-io/bbolt v1.3.0
-	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/testify v1.2.2
 	go.uber.org/dig v1.3.0
+)
+
+require (
+	github.com/boltdb/bolt v1.3.1 // indirect
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/sys v0.0.0-20180928133829-e4b3c5e90611 // indirect
 )
@@ -1,28 +1,198 @@
 package config
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
 	"runtime"
+	"strings"
 	"time"
 )
 
+var (
+	// ErrInvalidConfig is thrown when a config fails validation
+	ErrInvalidConfig = errors.New("invalid config")
+)
+
+// Listener is an address the server accepts connections on
+type Listener struct {
+	// Network is tcp, tcp4, tcp6 or unix
+	Network string
+	// Address is host:port for tcp or the socket path for unix
+	Address string
+	// Perm sets the permissions of a unix socket, zero leaves the umask default
+	Perm os.FileMode
+	// TLS serves the listener over TLS using the TLS settings of the config
+	TLS bool
+	// ProxyProtocol expects a PROXY protocol v1 or v2 header ahead of each connection
+	ProxyProtocol bool
+	// ProxyTrusted limits the sources allowed to send a PROXY protocol header to
+	// these IP addresses and CIDRs, connections from anywhere else are served directly
+	ProxyTrusted []string
+}
+
 // Config defines the configuration for the RESP server
 type Config struct {
-	Host             string
+	Listeners        []Listener
 	Workers          int
+	MaxClients       int
 	ReadTimeout      time.Duration
 	WriteTimeout     time.Duration
+	ShutdownTimeout  time.Duration
 	DatabaseLocation string
 	RequirePass      string
+	ChunkCacheSize   int64
+	ChunkIdleTimeout time.Duration
+	PathMaxNodes     int
+	ProtoMaxBulkLen  int64
+	MaxMultiBulkLen  int64
+	MaxInlineLen     int
+	MaxNesting       int
+	TLSCertFile      string
+	TLSKeyFile       string
+	TLSCACertFile    string
+	TLSMinVersion    string
+	TLSCiphers       []string
+	TLSAuthClients   string
 }
 
 // NewConfig reads a new config
 func NewConfig() *Config {
 	return &Config{
-		Host:             "127.0.0.1:3030",
+		Listeners:        []Listener{{Network: "tcp", Address: "127.0.0.1:3030"}},
 		Workers:          runtime.NumCPU(),
+		MaxClients:       10000,
 		ReadTimeout:      5 * time.Minute,
 		WriteTimeout:     5 * time.Minute,
+		ShutdownTimeout:  10 * time.Second,
 		DatabaseLocation: "/var/local/gochunk",
 		RequirePass:      "",
+		ChunkCacheSize:   64 << 20,
+		ChunkIdleTimeout: 10 * time.Minute,
+		PathMaxNodes:     100000,
+		ProtoMaxBulkLen:  512 << 20,
+		MaxMultiBulkLen:  1024 * 1024,
+		MaxInlineLen:     64 * 1024,
+		MaxNesting:       8,
+		TLSMinVersion:    "1.2",
+		TLSAuthClients:   "no",
+	}
+}
+
+func invalid(format string, a ...interface{}) error {
+	return fmt.Errorf("%s: %s", ErrInvalidConfig, fmt.Sprintf(format, a...))
+}
+
+func validateListener(l Listener) error {
+	switch l.Network {
+	case "tcp", "tcp4", "tcp6":
+		if _, _, err := net.SplitHostPort(l.Address); err != nil {
+			return invalid("listener address %s isn't host:port", l.Address)
+		}
+	case "unix":
+		if l.Address == "" {
+			return invalid("unix listener needs a socket path")
+		}
+	default:
+		return invalid("listener network %s must be tcp, tcp4, tcp6 or unix", l.Network)
+	}
+	for _, t := range l.ProxyTrusted {
+		if _, _, err := net.ParseCIDR(t); err != nil && net.ParseIP(t) == nil {
+			return invalid("trusted PROXY protocol source %s isn't an IP address or CIDR", t)
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateTLS() error {
+	if c.TLSCertFile == "" || c.TLSKeyFile == "" {
+		return invalid("TLS listeners need tls-cert-file and tls-key-file")
+	}
+	for _, f := range []string{c.TLSCertFile, c.TLSKeyFile, c.TLSCACertFile} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			return invalid("can't read TLS file: %s", err)
+		}
+	}
+	switch c.TLSMinVersion {
+	case "1.0", "1.1", "1.2", "1.3":
+	default:
+		return invalid("tls-min-version %s must be 1.0, 1.1, 1.2 or 1.3", c.TLSMinVersion)
+	}
+	switch strings.ToLower(c.TLSAuthClients) {
+	case "no":
+	case "optional", "yes":
+		if c.TLSCACertFile == "" {
+			return invalid("tls-auth-clients %s needs tls-ca-cert-file to verify clients", c.TLSAuthClients)
+		}
+	default:
+		return invalid("tls-auth-clients %s must be no, optional or yes", c.TLSAuthClients)
+	}
+	suites := map[string]bool{}
+	for _, s := range tls.CipherSuites() {
+		suites[s.Name] = true
+	}
+	for _, name := range c.TLSCiphers {
+		if !suites[strings.ToUpper(name)] {
+			return invalid("unknown TLS cipher suite %s", name)
+		}
+	}
+	return nil
+}
+
+// Validate checks the config is usable before anything is started with it
+func (c *Config) Validate() error {
+	if len(c.Listeners) == 0 {
+		return invalid("nothing to listen on, set port, tls-port or unixsocket")
+	}
+	useTLS := false
+	for _, l := range c.Listeners {
+		if err := validateListener(l); err != nil {
+			return err
+		}
+		useTLS = useTLS || l.TLS
+	}
+	if useTLS {
+		if err := c.validateTLS(); err != nil {
+			return err
+		}
+	}
+	switch {
+	case c.Workers < 1:
+		return invalid("workers must be at least 1")
+	case c.MaxClients < 1:
+		return invalid("maxclients must be at least 1")
+	case c.ReadTimeout <= 0:
+		return invalid("read-timeout must be positive")
+	case c.WriteTimeout <= 0:
+		return invalid("write-timeout must be positive")
+	case c.ShutdownTimeout < 0:
+		return invalid("shutdown-timeout can't be negative")
+	case c.DatabaseLocation == "":
+		return invalid("dir must be set")
+	case c.ChunkCacheSize <= 0:
+		return invalid("chunk-cache-size must be positive")
+	case c.ChunkIdleTimeout < 0:
+		return invalid("chunk-idle-timeout can't be negative")
+	case c.PathMaxNodes < 1:
+		return invalid("path-max-nodes must be at least 1")
+	case c.ProtoMaxBulkLen < 0:
+		return invalid("proto-max-bulk-len can't be negative")
+	case c.MaxMultiBulkLen < 0:
+		return invalid("max-multibulk-len can't be negative")
+	case c.MaxInlineLen < 0:
+		return invalid("max-inline-len can't be negative")
+	case c.MaxNesting < 0:
+		return invalid("max-nesting can't be negative")
+	}
+	if fi, err := os.Stat(c.DatabaseLocation); err != nil {
+		return invalid("can't use dir: %s", err)
+	} else if !fi.IsDir() {
+		return invalid("dir %s isn't a directory", c.DatabaseLocation)
 	}
+	return nil
 }
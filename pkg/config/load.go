@@ -0,0 +1,484 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/furui/gochunk/pkg/types"
+)
+
+const (
+	// EnvPrefix starts the name of every environment variable the config reads
+	EnvPrefix = "GOCHUNK_"
+	// EnvConfigFile names a config file to load when the -config flag isn't given
+	EnvConfigFile = EnvPrefix + "CONFIG"
+)
+
+var (
+	// ErrUnknownOption is thrown when a config file, environment variable or flag names an option that doesn't exist
+	ErrUnknownOption = errors.New("unknown option")
+	// ErrArguments is thrown when an option is given the wrong number of arguments
+	ErrArguments = errors.New("wrong number of arguments")
+)
+
+// network collects the options that describe the listeners until they're built
+type network struct {
+	bind           []string
+	port           int
+	tlsPort        int
+	unixSocket     string
+	unixSocketPerm os.FileMode
+	proxyProtocol  bool
+	proxyTrusted   []string
+}
+
+// listeners opens port and tls-port on every bind address, plus the unix socket
+func (n *network) listeners() []Listener {
+	listeners := []Listener{}
+	for _, p := range []struct {
+		port int
+		tls  bool
+	}{{n.port, false}, {n.tlsPort, true}} {
+		if p.port == 0 {
+			continue
+		}
+		for _, host := range n.bind {
+			// Like Redis, * binds every interface
+			if host == "*" {
+				host = ""
+			}
+			listeners = append(listeners, Listener{
+				Network:       "tcp",
+				Address:       net.JoinHostPort(host, strconv.Itoa(p.port)),
+				TLS:           p.tls,
+				ProxyProtocol: n.proxyProtocol,
+				ProxyTrusted:  n.proxyTrusted,
+			})
+		}
+	}
+	if n.unixSocket != "" {
+		listeners = append(listeners, Listener{Network: "unix", Address: n.unixSocket, Perm: n.unixSocketPerm})
+	}
+	return listeners
+}
+
+type loader struct {
+	config  *Config
+	network network
+}
+
+// option is a setting that can be given in a config file, as an environment variable or as a flag
+type option struct {
+	name  string
+	usage string
+	set   func(l *loader, args []string) error
+}
+
+// listOptions take several arguments, as environment variables and flags they're split on whitespace
+var listOptions = map[string]bool{
+	"bind":                   true,
+	"proxy-protocol-trusted": true,
+	"tls-ciphers":            true,
+}
+
+var options = []option{
+	{"bind", "addresses to listen on, * for every interface", func(l *loader, args []string) error {
+		if len(args) == 0 {
+			return ErrArguments
+		}
+		l.network.bind = args
+		return nil
+	}},
+	{"port", "TCP port, 0 disables plain TCP", func(l *loader, args []string) error {
+		return setPort(&l.network.port, args)
+	}},
+	{"tls-port", "TLS port, 0 disables TLS", func(l *loader, args []string) error {
+		return setPort(&l.network.tlsPort, args)
+	}},
+	{"unixsocket", "unix socket path, empty disables the socket", func(l *loader, args []string) error {
+		return setString(&l.network.unixSocket, args)
+	}},
+	{"unixsocketperm", "unix socket permissions in octal", func(l *loader, args []string) error {
+		if len(args) != 1 {
+			return ErrArguments
+		}
+		v, err := strconv.ParseUint(args[0], 8, 32)
+		if err != nil || v > 0777 {
+			return fmt.Errorf("invalid permissions '%s', expected octal such as 700", args[0])
+		}
+		l.network.unixSocketPerm = os.FileMode(v)
+		return nil
+	}},
+	{"proxy-protocol", "expect PROXY protocol headers on TCP listeners, yes or no", func(l *loader, args []string) error {
+		return setBool(&l.network.proxyProtocol, args)
+	}},
+	{"proxy-protocol-trusted", "addresses and CIDRs allowed to send PROXY protocol headers, none trusts everyone", func(l *loader, args []string) error {
+		l.network.proxyTrusted = args
+		return nil
+	}},
+	{"workers", "commands executed at once", func(l *loader, args []string) error {
+		return setInt(&l.config.Workers, args)
+	}},
+	{"maxclients", "connections served at once", func(l *loader, args []string) error {
+		return setInt(&l.config.MaxClients, args)
+	}},
+	{"read-timeout", "idle time allowed between commands", func(l *loader, args []string) error {
+		return setDuration(&l.config.ReadTimeout, args)
+	}},
+	{"write-timeout", "time allowed to write a reply", func(l *loader, args []string) error {
+		return setDuration(&l.config.WriteTimeout, args)
+	}},
+	{"shutdown-timeout", "time allowed for commands to finish on shutdown", func(l *loader, args []string) error {
+		return setDuration(&l.config.ShutdownTimeout, args)
+	}},
+	{"dir", "directory the databases are stored in", func(l *loader, args []string) error {
+		return setString(&l.config.DatabaseLocation, args)
+	}},
+	{"requirepass", "password clients must AUTH with, empty disables AUTH", func(l *loader, args []string) error {
+		return setString(&l.config.RequirePass, args)
+	}},
+	{"chunk-cache-size", "memory for tile chunks across all databases, such as 64mb", func(l *loader, args []string) error {
+		return setBytes(&l.config.ChunkCacheSize, args)
+	}},
+	{"chunk-idle-timeout", "time before an unused chunk is evicted, 0 keeps chunks until the cache is full", func(l *loader, args []string) error {
+		return setDuration(&l.config.ChunkIdleTimeout, args)
+	}},
+	{"path-max-nodes", "most tiles a WPATH search may expand, the largest MAXNODES accepted", func(l *loader, args []string) error {
+		return setInt(&l.config.PathMaxNodes, args)
+	}},
+	{"proto-max-bulk-len", "largest bulk string accepted, 0 is unlimited", func(l *loader, args []string) error {
+		return setBytes(&l.config.ProtoMaxBulkLen, args)
+	}},
+	{"max-multibulk-len", "most elements accepted in an aggregate, 0 is unlimited", func(l *loader, args []string) error {
+		return setInt64(&l.config.MaxMultiBulkLen, args)
+	}},
+	{"max-inline-len", "longest inline command or header line accepted, 0 is unlimited", func(l *loader, args []string) error {
+		var v int64
+		if err := setBytes(&v, args); err != nil {
+			return err
+		}
+		if v > math.MaxInt32 {
+			return fmt.Errorf("'%s' is too large", args[0])
+		}
+		l.config.MaxInlineLen = int(v)
+		return nil
+	}},
+	{"max-nesting", "deepest nesting of aggregates accepted, 0 is unlimited", func(l *loader, args []string) error {
+		return setInt(&l.config.MaxNesting, args)
+	}},
+	{"tls-cert-file", "server certificate", func(l *loader, args []string) error {
+		return setString(&l.config.TLSCertFile, args)
+	}},
+	{"tls-key-file", "server certificate's private key", func(l *loader, args []string) error {
+		return setString(&l.config.TLSKeyFile, args)
+	}},
+	{"tls-ca-cert-file", "CA client certificates are verified against", func(l *loader, args []string) error {
+		return setString(&l.config.TLSCACertFile, args)
+	}},
+	{"tls-min-version", "oldest TLS version accepted, 1.0, 1.1, 1.2 or 1.3", func(l *loader, args []string) error {
+		return setString(&l.config.TLSMinVersion, args)
+	}},
+	{"tls-ciphers", "TLS 1.2 and older cipher suites, separated by spaces or colons", func(l *loader, args []string) error {
+		ciphers := []string{}
+		for _, a := range args {
+			for _, c := range strings.Split(a, ":") {
+				if c != "" {
+					ciphers = append(ciphers, c)
+				}
+			}
+		}
+		l.config.TLSCiphers = ciphers
+		return nil
+	}},
+	{"tls-auth-clients", "client certificates, no, optional or yes", func(l *loader, args []string) error {
+		return setString(&l.config.TLSAuthClients, args)
+	}},
+}
+
+func findOption(name string) (option, bool) {
+	for _, o := range options {
+		if o.name == name {
+			return o, true
+		}
+	}
+	return option{}, false
+}
+
+func setString(v *string, args []string) error {
+	switch len(args) {
+	case 0:
+		*v = ""
+	case 1:
+		*v = args[0]
+	default:
+		return ErrArguments
+	}
+	return nil
+}
+
+func setInt64(v *int64, args []string) error {
+	if len(args) != 1 {
+		return ErrArguments
+	}
+	i, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer '%s'", args[0])
+	}
+	*v = i
+	return nil
+}
+
+func setInt(v *int, args []string) error {
+	var i int64
+	if err := setInt64(&i, args); err != nil {
+		return err
+	}
+	if i > math.MaxInt32 || i < math.MinInt32 {
+		return fmt.Errorf("'%s' is out of range", args[0])
+	}
+	*v = int(i)
+	return nil
+}
+
+func setPort(v *int, args []string) error {
+	var p int
+	if err := setInt(&p, args); err != nil {
+		return err
+	}
+	if p < 0 || p > 65535 {
+		return fmt.Errorf("invalid port '%s'", args[0])
+	}
+	*v = p
+	return nil
+}
+
+func setBool(v *bool, args []string) error {
+	if len(args) != 1 {
+		return ErrArguments
+	}
+	switch strings.ToLower(args[0]) {
+	case "yes":
+		*v = true
+	case "no":
+		*v = false
+	default:
+		return fmt.Errorf("invalid value '%s', expected yes or no", args[0])
+	}
+	return nil
+}
+
+// setDuration accepts Go durations such as 1m30s, or a number of seconds
+func setDuration(v *time.Duration, args []string) error {
+	if len(args) != 1 {
+		return ErrArguments
+	}
+	if s, err := strconv.ParseInt(args[0], 10, 64); err == nil {
+		if s < 0 || s > math.MaxInt64/int64(time.Second) {
+			return fmt.Errorf("invalid duration '%s'", args[0])
+		}
+		*v = time.Duration(s) * time.Second
+		return nil
+	}
+	d, err := time.ParseDuration(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid duration '%s', expected seconds or a duration such as 1m30s", args[0])
+	}
+	*v = d
+	return nil
+}
+
+// setBytes accepts a number of bytes with an optional unit like redis.conf,
+// k, m and g are powers of 1000 while kb, mb and gb are powers of 1024
+func setBytes(v *int64, args []string) error {
+	if len(args) != 1 {
+		return ErrArguments
+	}
+	s := strings.ToLower(args[0])
+	mul := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mul    int64
+	}{{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30}, {"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mul = strings.TrimSuffix(s, u.suffix), u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/mul {
+		return fmt.Errorf("invalid size '%s', expected bytes such as 512mb", args[0])
+	}
+	*v = n * mul
+	return nil
+}
+
+func (l *loader) apply(name string, args []string) error {
+	o, ok := findOption(name)
+	if !ok {
+		return fmt.Errorf("%s '%s'", ErrUnknownOption, name)
+	}
+	return o.set(l, args)
+}
+
+func splitArgs(line string) ([]string, error) {
+	parts, err := types.SplitArgs([]byte(line))
+	if err == types.ErrUnbalancedQuotes {
+		return nil, fmt.Errorf("unbalanced quotes")
+	}
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, len(parts))
+	for i, p := range parts {
+		args[i] = string(p)
+	}
+	return args, nil
+}
+
+// applyLine applies a config file line, the option name followed by its arguments
+func (l *loader) applyLine(line string) error {
+	args, err := splitArgs(line)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return l.apply(strings.ToLower(args[0]), args[1:])
+}
+
+// applyValue applies an environment variable or flag, the shell has already unquoted it
+// so it's taken literally unless the option is a list
+func (l *loader) applyValue(name string, value string) error {
+	if listOptions[name] {
+		return l.apply(name, strings.Fields(value))
+	}
+	return l.apply(name, []string{value})
+}
+
+// readFile applies a redis.conf style file, one option and its arguments per line
+func (l *loader) readFile(r io.Reader, name string) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if err := l.applyLine(line); err != nil {
+			return fmt.Errorf("%s:%d: %s", name, n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// envName is the environment variable for an option, such as GOCHUNK_TLS_PORT for tls-port
+func envName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+func (l *loader) readEnv(environ []string) error {
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, EnvPrefix) {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i < 0 || kv[:i] == EnvConfigFile {
+			continue
+		}
+		key, value := kv[:i], kv[i+1:]
+		name := strings.ToLower(strings.Replace(strings.TrimPrefix(key, EnvPrefix), "_", "-", -1))
+		if err := l.applyValue(name, value); err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+	}
+	return nil
+}
+
+func env(environ []string, key string) string {
+	for _, kv := range environ {
+		if strings.HasPrefix(kv, key+"=") {
+			return kv[len(key)+1:]
+		}
+	}
+	return ""
+}
+
+// Load builds the config from the defaults, then a config file, then GOCHUNK_ environment
+// variables and finally command line flags, each overriding the last, and validates it
+func Load(args []string, environ []string) (*Config, error) {
+	fs := flag.NewFlagSet("gochunk", flag.ContinueOnError)
+	conf, err := LoadFlags(fs, args, environ)
+	if err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument '%s'", fs.Arg(0))
+	}
+	return conf, nil
+}
+
+// LoadFlags is Load for commands with flags of their own, they're defined on fs beforehand
+// and the arguments left after the flags are in fs.Args()
+func LoadFlags(fs *flag.FlagSet, args []string, environ []string) (*Config, error) {
+	l := &loader{
+		config: NewConfig(),
+		network: network{
+			bind: []string{"127.0.0.1"},
+			port: 3030,
+		},
+	}
+
+	file := fs.String("config", "", fmt.Sprintf("config file to load, also read from %s", EnvConfigFile))
+	for _, o := range options {
+		fs.String(o.name, "", fmt.Sprintf("%s (%s)", o.usage, envName(o.name)))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	path := *file
+	if path == "" {
+		path = env(environ, EnvConfigFile)
+	}
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		err = l.readFile(f, path)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := l.readEnv(environ); err != nil {
+		return nil, err
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if _, ok := findOption(f.Name); err != nil || !ok {
+			return
+		}
+		if e := l.applyValue(f.Name, f.Value.String()); e != nil {
+			err = fmt.Errorf("-%s: %s", f.Name, e)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	l.config.Listeners = l.network.listeners()
+	if err := l.config.Validate(); err != nil {
+		return nil, err
+	}
+	return l.config, nil
+}
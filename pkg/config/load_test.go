@@ -0,0 +1,265 @@
+package config_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/furui/gochunk/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "gochunk")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func writeFile(t *testing.T, dir string, name string, data string) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0600))
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	dir := tempDir(t)
+	want := config.NewConfig()
+	want.DatabaseLocation = dir
+
+	conf, err := config.Load([]string{"-dir", dir}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, want, conf)
+
+	// The sample config documents the defaults
+	conf, err = config.Load([]string{"-config", "../../gochunk.conf", "-dir", dir}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, want, conf)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := tempDir(t)
+	file := writeFile(t, dir, "gochunk.conf", "port 4000\nrequirepass file\nworkers 2\n")
+
+	conf, err := config.Load([]string{"-config", file, "-dir", dir, "-requirepass", "flag"}, []string{
+		"GOCHUNK_PORT=5000",
+		"GOCHUNK_REQUIREPASS=env",
+		"PATH=/bin",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []config.Listener{{Network: "tcp", Address: "127.0.0.1:5000"}}, conf.Listeners)
+	assert.Equal(t, "flag", conf.RequirePass)
+	assert.Equal(t, 2, conf.Workers)
+
+	// The config file can be named by the environment too
+	conf, err = config.Load([]string{"-dir", dir}, []string{"GOCHUNK_CONFIG=" + file})
+	assert.NoError(t, err)
+	assert.Equal(t, "file", conf.RequirePass)
+}
+
+func TestLoadLiteralValues(t *testing.T) {
+	dir := filepath.Join(tempDir(t), "my worlds")
+	assert.NoError(t, os.Mkdir(dir, 0700))
+
+	// The shell already split and unquoted environment variables and flags
+	conf, err := config.Load([]string{"-requirepass", `a "b\x41`, "-tls-ciphers", "A:B  C"}, []string{
+		"GOCHUNK_DIR=" + dir,
+		"GOCHUNK_BIND=127.0.0.1 ::1",
+		"GOCHUNK_PROXY_PROTOCOL_TRUSTED=10.0.0.0/8 192.0.2.1",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, dir, conf.DatabaseLocation)
+	assert.Equal(t, `a "b\x41`, conf.RequirePass)
+	assert.Equal(t, []string{"A", "B", "C"}, conf.TLSCiphers)
+	assert.Equal(t, []config.Listener{
+		{Network: "tcp", Address: "127.0.0.1:3030", ProxyTrusted: []string{"10.0.0.0/8", "192.0.2.1"}},
+		{Network: "tcp", Address: "[::1]:3030", ProxyTrusted: []string{"10.0.0.0/8", "192.0.2.1"}},
+	}, conf.Listeners)
+
+	// An empty value clears a string option
+	conf, err = config.Load([]string{"-dir", dir, "-requirepass", ""}, []string{"GOCHUNK_REQUIREPASS=env"})
+	assert.NoError(t, err)
+	assert.Equal(t, "", conf.RequirePass)
+}
+
+func TestLoadFlags(t *testing.T) {
+	dir := tempDir(t)
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	index := fs.Int("db", 0, "database index")
+	conf, err := config.LoadFlags(fs, []string{"-db", "3", "w"}, []string{"GOCHUNK_DIR=" + dir})
+	assert.NoError(t, err)
+	assert.Equal(t, dir, conf.DatabaseLocation)
+	assert.Equal(t, 3, *index)
+	assert.Equal(t, []string{"w"}, fs.Args())
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := tempDir(t)
+	cert := writeFile(t, dir, "server.crt", "")
+	key := writeFile(t, dir, "server.key", "")
+	sock := filepath.Join(dir, "gochunk.sock")
+	file := writeFile(t, dir, "gochunk.conf", `
+# comments and blank lines are skipped
+   # even indented ones
+
+BIND 127.0.0.1 ::1
+port 3031
+tls-port 3032
+unixsocket "`+sock+`"
+unixsocketperm 660
+proxy-protocol yes
+proxy-protocol-trusted 10.0.0.0/8 192.0.2.1
+dir "`+dir+`"
+requirepass "two words \x21"
+maxclients 50
+workers 3
+read-timeout 30
+write-timeout 1m30s
+shutdown-timeout 0
+chunk-cache-size 1gb
+chunk-idle-timeout 0
+proto-max-bulk-len 1m
+max-multibulk-len 100
+max-inline-len 4kb
+max-nesting 0
+path-max-nodes 500
+tls-cert-file `+cert+`
+tls-key-file `+key+`
+tls-min-version 1.3
+tls-ciphers TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256:TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+tls-auth-clients no
+`)
+	conf, err := config.Load([]string{"-config", file}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	trusted := []string{"10.0.0.0/8", "192.0.2.1"}
+	assert.Equal(t, []config.Listener{
+		{Network: "tcp", Address: "127.0.0.1:3031", ProxyProtocol: true, ProxyTrusted: trusted},
+		{Network: "tcp", Address: "[::1]:3031", ProxyProtocol: true, ProxyTrusted: trusted},
+		{Network: "tcp", Address: "127.0.0.1:3032", TLS: true, ProxyProtocol: true, ProxyTrusted: trusted},
+		{Network: "tcp", Address: "[::1]:3032", TLS: true, ProxyProtocol: true, ProxyTrusted: trusted},
+		{Network: "unix", Address: sock, Perm: 0660},
+	}, conf.Listeners)
+	assert.Equal(t, dir, conf.DatabaseLocation)
+	assert.Equal(t, "two words !", conf.RequirePass)
+	assert.Equal(t, 50, conf.MaxClients)
+	assert.Equal(t, 3, conf.Workers)
+	assert.Equal(t, 30*time.Second, conf.ReadTimeout)
+	assert.Equal(t, 90*time.Second, conf.WriteTimeout)
+	assert.Equal(t, time.Duration(0), conf.ShutdownTimeout)
+	assert.Equal(t, int64(1<<30), conf.ChunkCacheSize)
+	assert.Equal(t, time.Duration(0), conf.ChunkIdleTimeout)
+	assert.Equal(t, int64(1000*1000), conf.ProtoMaxBulkLen)
+	assert.Equal(t, int64(100), conf.MaxMultiBulkLen)
+	assert.Equal(t, 4096, conf.MaxInlineLen)
+	assert.Equal(t, 0, conf.MaxNesting)
+	assert.Equal(t, 500, conf.PathMaxNodes)
+	assert.Equal(t, cert, conf.TLSCertFile)
+	assert.Equal(t, key, conf.TLSKeyFile)
+	assert.Equal(t, "1.3", conf.TLSMinVersion)
+	assert.Equal(t, []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, conf.TLSCiphers)
+
+	// Every interface and no plain port
+	conf, err = config.Load([]string{"-dir", dir, "-bind", "*", "-port", "0", "-unixsocket", sock}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []config.Listener{
+		{Network: "unix", Address: sock},
+	}, conf.Listeners)
+	conf, err = config.Load([]string{"-dir", dir, "-bind", "*"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []config.Listener{{Network: "tcp", Address: ":3030"}}, conf.Listeners)
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := tempDir(t)
+	testCases := []struct {
+		desc    string
+		file    string
+		args    []string
+		environ []string
+		err     string
+	}{
+		{desc: "unknown option", file: "port 3030\nnope 1\n", err: "gochunk.conf:3: unknown option 'nope'"},
+		{desc: "unbalanced quotes", file: "requirepass \"open\n", err: "gochunk.conf:2: unbalanced quotes"},
+		{desc: "arguments", file: "port 1 2\n", err: "gochunk.conf:2: wrong number of arguments"},
+		{desc: "integer", file: "workers many\n", err: "gochunk.conf:2: invalid integer 'many'"},
+		{desc: "port", file: "port 70000\n", err: "gochunk.conf:2: invalid port '70000'"},
+		{desc: "bool", file: "proxy-protocol maybe\n", err: "gochunk.conf:2: invalid value 'maybe', expected yes or no"},
+		{desc: "perm", file: "unixsocketperm 999\n", err: "gochunk.conf:2: invalid permissions '999', expected octal such as 700"},
+		{desc: "duration", file: "read-timeout soon\n", err: "gochunk.conf:2: invalid duration 'soon', expected seconds or a duration such as 1m30s"},
+		{desc: "size", file: "chunk-cache-size 12tb\n", err: "gochunk.conf:2: invalid size '12tb', expected bytes such as 512mb"},
+		{desc: "size overflow", file: "chunk-cache-size 99999999999gb\n", err: "gochunk.conf:2: invalid size '99999999999gb', expected bytes such as 512mb"},
+		{desc: "env option", environ: []string{"GOCHUNK_NOPE=1"}, err: "GOCHUNK_NOPE: unknown option 'nope'"},
+		{desc: "env value", environ: []string{"GOCHUNK_MAX_NESTING=deep"}, err: "GOCHUNK_MAX_NESTING: invalid integer 'deep'"},
+		{desc: "flag value", args: []string{"-maxclients", "lots"}, err: "-maxclients: invalid integer 'lots'"},
+		{desc: "unknown flag", args: []string{"-nope"}, err: "flag provided but not defined: -nope"},
+		{desc: "argument", args: []string{"extra"}, err: "unexpected argument 'extra'"},
+		{desc: "missing file", args: []string{"-config", filepath.Join(dir, "missing.conf")}, err: "no such file or directory"},
+		{desc: "no listeners", file: "port 0\n", err: "invalid config: nothing to listen on, set port, tls-port or unixsocket"},
+		{desc: "tls files", file: "tls-port 3031\n", err: "invalid config: TLS listeners need tls-cert-file and tls-key-file"},
+		{desc: "tls missing file", file: "tls-port 3031\ntls-cert-file /nonexistent.crt\ntls-key-file /nonexistent.key\n", err: "invalid config: can't read TLS file"},
+		{desc: "trusted", file: "proxy-protocol yes\nproxy-protocol-trusted 10.0.0.0/33\n", err: "invalid config: trusted PROXY protocol source 10.0.0.0/33 isn't an IP address or CIDR"},
+		{desc: "workers", file: "workers 0\n", err: "invalid config: workers must be at least 1"},
+		{desc: "maxclients", file: "maxclients -1\n", err: "invalid config: maxclients must be at least 1"},
+		{desc: "read timeout", file: "read-timeout 0\n", err: "invalid config: read-timeout must be positive"},
+		{desc: "negative duration", file: "shutdown-timeout -1s\n", err: "invalid config: shutdown-timeout can't be negative"},
+		{desc: "cache size", file: "chunk-cache-size 0\n", err: "invalid config: chunk-cache-size must be positive"},
+		{desc: "nesting", file: "max-nesting -1\n", err: "invalid config: max-nesting can't be negative"},
+		{desc: "path nodes", file: "path-max-nodes 0\n", err: "invalid config: path-max-nodes must be at least 1"},
+		{desc: "missing dir", file: "dir " + filepath.Join(dir, "missing") + "\n", err: "invalid config: can't use dir"},
+		{desc: "dir is a file", file: "dir " + filepath.Join(dir, "gochunk.conf") + "\n", err: "isn't a directory"},
+		{desc: "empty dir", file: "dir \"\"\n", err: "invalid config: dir must be set"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			args := []string{"-dir", dir}
+			if tC.file != "" {
+				args = []string{"-config", writeFile(t, dir, "gochunk.conf", "dir "+dir+"\n"+tC.file)}
+			}
+			_, err := config.Load(append(args, tC.args...), tC.environ)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tC.err)
+			}
+		})
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	dir := tempDir(t)
+	conf := config.NewConfig()
+	conf.DatabaseLocation = dir
+	conf.Listeners = []config.Listener{{Network: "tcp", Address: "127.0.0.1:0", TLS: true}}
+	conf.TLSCertFile = writeFile(t, dir, "server.crt", "")
+	conf.TLSKeyFile = writeFile(t, dir, "server.key", "")
+	assert.NoError(t, conf.Validate())
+
+	testCases := []struct {
+		desc string
+		fn   func(*config.Config)
+		err  string
+	}{
+		{desc: "version", fn: func(c *config.Config) { c.TLSMinVersion = "1.4" }, err: "tls-min-version 1.4 must be 1.0, 1.1, 1.2 or 1.3"},
+		{desc: "auth clients", fn: func(c *config.Config) { c.TLSAuthClients = "always" }, err: "tls-auth-clients always must be no, optional or yes"},
+		{desc: "auth clients without ca", fn: func(c *config.Config) { c.TLSAuthClients = "yes" }, err: "tls-auth-clients yes needs tls-ca-cert-file"},
+		{desc: "cipher", fn: func(c *config.Config) { c.TLSCiphers = []string{"RC4"} }, err: "unknown TLS cipher suite RC4"},
+		{desc: "network", fn: func(c *config.Config) { c.Listeners[0].Network = "udp" }, err: "listener network udp must be tcp, tcp4, tcp6 or unix"},
+		{desc: "address", fn: func(c *config.Config) { c.Listeners[0].Address = "localhost" }, err: "listener address localhost isn't host:port"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			c := *conf
+			c.Listeners = append([]config.Listener{}, conf.Listeners...)
+			tC.fn(&c)
+			err := c.Validate()
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tC.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package db
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+)
+
+// worldID names a world within one of the databases sharing a cache
+type worldID struct {
+	db    string
+	world string
+}
+
+type chunkID struct {
+	worldID
+	cx int64
+	cy int64
+}
+
+type residentChunk struct {
+	id   chunkID
+	data []byte
+	used time.Time
+}
+
+// chunkCache keeps recently used tile chunks in memory, evicting the least
+// recently used once over budget and any chunk left idle for too long.
+// Chunks are read from disk without holding the lock, a world's generation
+// changes with every write so reads that raced a write aren't kept. A manager
+// shares one cache between its databases, so the budget covers all of them.
+type chunkCache struct {
+	mux    sync.Mutex
+	chunks map[chunkID]*list.Element
+	gens   map[worldID]uint64
+	lru    *list.List
+	size   int64
+	budget int64
+	idle   time.Duration
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func newChunkCache(budget int64, idle time.Duration) *chunkCache {
+	c := &chunkCache{
+		chunks: make(map[chunkID]*list.Element),
+		gens:   make(map[worldID]uint64),
+		lru:    list.New(),
+		budget: budget,
+		idle:   idle,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go c.janitor()
+	return c
+}
+
+func (c *chunkCache) janitor() {
+	defer close(c.done)
+	if c.idle <= 0 {
+		<-c.stop
+		return
+	}
+	interval := c.idle / 2
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			c.mux.Lock()
+			c.evictIdle(now)
+			c.mux.Unlock()
+		}
+	}
+}
+
+// lookup returns a resident chunk, or the generation of its world to store it with once read from disk
+func (c *chunkCache) lookup(id chunkID) ([]byte, uint64, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if data, ok := c.get(id); ok {
+		return data, 0, true
+	}
+	return nil, c.gens[id.worldID], false
+}
+
+// store makes a chunk read from disk resident, unless its world was written since the lookup
+func (c *chunkCache) store(id chunkID, data []byte, gen uint64) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.gens[id.worldID] != gen {
+		return
+	}
+	c.put(id, data)
+}
+
+// has reports whether a chunk is resident
+func (c *chunkCache) has(id chunkID) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	_, ok := c.chunks[id]
+	return ok
+}
+
+// refresh replaces the data of written chunks that are resident, other chunks are left on
+// disk. Chunks written without data weren't resident during the write, a read may have
+// loaded them since and they're evicted.
+func (c *chunkCache) refresh(w worldID, written map[chunkID][]byte) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.gens[w]++
+	for id, data := range written {
+		e, ok := c.chunks[id]
+		if !ok {
+			continue
+		}
+		if data == nil {
+			c.remove(e)
+		} else {
+			c.put(id, data)
+		}
+	}
+}
+
+// get returns a resident chunk, the caller must hold the lock
+func (c *chunkCache) get(id chunkID) ([]byte, bool) {
+	e, ok := c.chunks[id]
+	if !ok {
+		return nil, false
+	}
+	r := e.Value.(*residentChunk)
+	r.used = time.Now()
+	c.lru.MoveToFront(e)
+	return r.data, true
+}
+
+// put makes a chunk resident, the caller must hold the lock
+func (c *chunkCache) put(id chunkID, data []byte) {
+	if e, ok := c.chunks[id]; ok {
+		r := e.Value.(*residentChunk)
+		c.size += int64(len(data) - len(r.data))
+		r.data = data
+		r.used = time.Now()
+		c.lru.MoveToFront(e)
+	} else {
+		c.chunks[id] = c.lru.PushFront(&residentChunk{id: id, data: data, used: time.Now()})
+		c.size += int64(len(data))
+	}
+	for c.size > c.budget && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *chunkCache) remove(e *list.Element) {
+	r := c.lru.Remove(e).(*residentChunk)
+	delete(c.chunks, r.id)
+	c.size -= int64(len(r.data))
+}
+
+func (c *chunkCache) evictIdle(now time.Time) {
+	for e := c.lru.Back(); e != nil; e = c.lru.Back() {
+		if now.Sub(e.Value.(*residentChunk).used) < c.idle {
+			return
+		}
+		c.remove(e)
+	}
+}
+
+// resident lists the coordinates of a world's chunks that are in memory
+func (c *chunkCache) resident(w worldID) [][2]int64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	coords := [][2]int64{}
+	for id := range c.chunks {
+		if id.worldID == w {
+			coords = append(coords, [2]int64{id.cx, id.cy})
+		}
+	}
+	sort.Slice(coords, func(i, j int) bool {
+		if coords[i][1] != coords[j][1] {
+			return coords[i][1] < coords[j][1]
+		}
+		return coords[i][0] < coords[j][0]
+	})
+	return coords
+}
+
+// usage returns how many of a world's chunks are in memory and their size in bytes
+func (c *chunkCache) usage(w worldID) (int64, int64) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	var count, size int64
+	for id, e := range c.chunks {
+		if id.worldID == w {
+			count++
+			size += int64(len(e.Value.(*residentChunk).data))
+		}
+	}
+	return count, size
+}
+
+func (c *chunkCache) close() {
+	close(c.stop)
+	<-c.done
+}
+
+// drop evicts every chunk of a world after its tiles were replaced
+func (c *chunkCache) drop(w worldID) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.gens[w]++
+	for id, e := range c.chunks {
+		if id.worldID == w {
+			c.remove(e)
+		}
+	}
+}
+
+// forget evicts every chunk of a database that's closing and its worlds' generations
+func (c *chunkCache) forget(db string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for id, e := range c.chunks {
+		if id.db == db {
+			c.remove(e)
+		}
+	}
+	for w := range c.gens {
+		if w.db == db {
+			delete(c.gens, w)
+		}
+	}
+}
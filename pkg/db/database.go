@@ -3,6 +3,7 @@ package db
 import (
 	"fmt"
 	"path/filepath"
+	"sync"
 
 	bbolt "github.com/etcd-io/bbolt"
 	"github.com/furui/gochunk/pkg/config"
@@ -10,27 +11,76 @@ import (
 
 // Database is a structure for accessing a database
 type Database interface {
+	Tile(world []byte, x int64, y int64) (byte, error)
+	SetTile(world []byte, x int64, y int64, v byte) error
+	FillTiles(world []byte, x1 int64, y1 int64, x2 int64, y2 int64, v byte) (int64, error)
+	TileChunk(world []byte, cx int64, cy int64) ([]byte, error)
+	ResidentChunks(world []byte) [][2]int64
+	TileStats(world []byte) (TileStats, error)
+	ForEachChunk(world []byte, fn func(cx int64, cy int64, data []byte) error) error
+	ReplaceTiles(world []byte, chunks map[[2]int64][]byte) error
+	Snapshot(world []byte, name []byte) error
+	Snapshots(world []byte) ([][]byte, error)
+	DeleteSnapshot(world []byte, name []byte) error
+	Rollback(world []byte, name []byte) error
+	Sync() error
 	Close() error
 }
 
 type database struct {
-	DB   *bbolt.DB
-	conf *config.Config
+	DB    *bbolt.DB
+	conf  *config.Config
+	name  string
+	tiles *chunkCache
+	// shared is set when the manager owns tiles and closes it
+	shared bool
+	// write orders tile commits with the cache updates that follow them
+	write sync.Mutex
 }
 
 // NewDatabase returns a database
 func NewDatabase(filename string, conf *config.Config) Database {
-	dbLocation := filepath.Join(conf.DatabaseLocation, fmt.Sprintf("%s.db", filename))
-	DB, err := bbolt.Open(dbLocation, 0666, nil)
+	d, err := openDatabase(filename, conf, nil, nil)
 	if err != nil {
 		panic(err)
 	}
-	return &database{
-		DB:   DB,
-		conf: conf,
+	return d
+}
+
+// openDatabase opens a database file, keeping its chunks in tiles or in a cache of its own when tiles is nil
+func openDatabase(filename string, conf *config.Config, options *bbolt.Options, tiles *chunkCache) (*database, error) {
+	dbLocation := filepath.Join(conf.DatabaseLocation, fmt.Sprintf("%s.db", filename))
+	DB, err := bbolt.Open(dbLocation, 0666, options)
+	if err != nil {
+		return nil, err
+	}
+	shared := tiles != nil
+	if !shared {
+		tiles = newChunkCache(conf.ChunkCacheSize, conf.ChunkIdleTimeout)
 	}
+	return &database{
+		DB:     DB,
+		conf:   conf,
+		name:   filename,
+		tiles:  tiles,
+		shared: shared,
+	}, nil
+}
+
+func (d *database) worldID(world []byte) worldID {
+	return worldID{db: d.name, world: string(world)}
+}
+
+// Sync flushes the database file to disk
+func (d *database) Sync() error {
+	return d.DB.Sync()
 }
 
 func (d *database) Close() error {
+	if d.shared {
+		d.tiles.forget(d.name)
+	} else {
+		d.tiles.close()
+	}
 	return d.DB.Close()
 }
@@ -3,9 +3,11 @@ package db
 import (
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
 	"strconv"
 	"sync"
+	"time"
 
 	bbolt "github.com/etcd-io/bbolt"
 	"github.com/furui/gochunk/pkg/uuid"
@@ -17,6 +19,7 @@ import (
 type Manager interface {
 	Swap(a int, b int) error
 	Get(id int) (Database, error)
+	Save() error
 	Close() error
 }
 
@@ -26,6 +29,7 @@ type manager struct {
 	conf      *config.Config
 	databases map[int]string
 	pool      map[string]Database
+	tiles     *chunkCache
 	mux       sync.Mutex
 	closed    bool
 }
@@ -37,6 +41,10 @@ var (
 	ErrorFirstIndexNonExistant = fmt.Errorf("first index non-existant")
 	// ErrorSecondIndexNonExistant returned when the second index doesn't exist
 	ErrorSecondIndexNonExistant = fmt.Errorf("second index non-existant")
+	// ErrorIndexNonExistant returned when a read-only open names an index that doesn't exist
+	ErrorIndexNonExistant = fmt.Errorf("index non-existant")
+	// ErrorDirectoryLocked returned when a read-only open can't share the data directory with a running server
+	ErrorDirectoryLocked = fmt.Errorf("data directory is locked, is the server running?")
 )
 
 // NewManager creates a new database manager
@@ -51,6 +59,7 @@ func NewManager(conf *config.Config, uuid uuid.Generator) Manager {
 		DB:     DB,
 		uuid:   uuid,
 		pool:   make(map[string]Database),
+		tiles:  newChunkCache(conf.ChunkCacheSize, conf.ChunkIdleTimeout),
 		closed: false,
 	}
 	err = m.load()
@@ -60,6 +69,45 @@ func NewManager(conf *config.Config, uuid uuid.Generator) Manager {
 	return m
 }
 
+// OpenReadOnly opens database id of a data directory without creating or changing anything
+// in it, waiting up to timeout for a running server to release the files
+func OpenReadOnly(conf *config.Config, id int, timeout time.Duration) (Database, error) {
+	options := &bbolt.Options{ReadOnly: true, Timeout: timeout}
+	dbLocation := filepath.Join(conf.DatabaseLocation, "manager.db")
+	// bbolt creates missing files even when opening read-only
+	if _, err := os.Stat(dbLocation); err != nil {
+		return nil, err
+	}
+	DB, err := bbolt.Open(dbLocation, 0666, options)
+	if err == bbolt.ErrTimeout {
+		return nil, ErrorDirectoryLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &manager{DB: DB}
+	err = m.load()
+	DB.Close()
+	if err != nil {
+		return nil, err
+	}
+	name, ok := m.databases[id]
+	if !ok {
+		return nil, ErrorIndexNonExistant
+	}
+	if _, err := os.Stat(filepath.Join(conf.DatabaseLocation, fmt.Sprintf("%s.db", name))); err != nil {
+		return nil, err
+	}
+	d, err := openDatabase(name, conf, options, nil)
+	if err == bbolt.ErrTimeout {
+		return nil, ErrorDirectoryLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
 func (m *manager) load() error {
 	m.mux.Lock()
 	defer m.mux.Unlock()
@@ -120,6 +168,9 @@ func (m *manager) generate(id int) string {
 func (m *manager) Swap(a int, b int) error {
 	m.mux.Lock()
 	defer m.mux.Unlock()
+	if m.closed == true {
+		return ErrorManagerClosed
+	}
 	aSide, ok := m.databases[a]
 	if !ok {
 		return ErrorFirstIndexNonExistant
@@ -147,21 +198,48 @@ func (m *manager) Get(id int) (Database, error) {
 	}
 	d, ok = m.pool[db]
 	if !ok {
-		d = NewDatabase(db, m.conf)
+		opened, err := openDatabase(db, m.conf, nil, m.tiles)
+		if err != nil {
+			return nil, err
+		}
+		d = opened
 		m.pool[db] = d
 	}
 	return d, nil
 }
 
+// Save flushes every open database file to disk
+func (m *manager) Save() error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.closed == true {
+		return ErrorManagerClosed
+	}
+	for _, v := range m.pool {
+		if err := v.Sync(); err != nil {
+			return err
+		}
+	}
+	return m.DB.Sync()
+}
+
+// Close waits for running transactions and closes every database, it's safe to call more than once
 func (m *manager) Close() error {
 	m.mux.Lock()
 	defer m.mux.Unlock()
+	if m.closed == true {
+		return nil
+	}
 	m.closed = true
+	var err error
 	for _, v := range m.pool {
-		err := v.Close()
-		if err != nil {
-			return err
+		if e := v.Close(); e != nil && err == nil {
+			err = e
 		}
 	}
-	return m.DB.Close()
+	m.tiles.close()
+	if e := m.DB.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
 }
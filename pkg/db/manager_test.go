@@ -1,10 +1,12 @@
 package db_test
 
 import (
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/furui/gochunk/pkg/db"
 	"github.com/furui/gochunk/pkg/uuid"
@@ -61,3 +63,111 @@ func TestGet(t *testing.T) {
 		assert.NotNil(t, first)
 	})
 }
+
+func TestClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gochunk")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	conf := config.NewConfig()
+	conf.DatabaseLocation = dir
+	manager := db.NewManager(conf, uuid.NewGenerator())
+	d, err := manager.Get(1)
+	assert.NoError(t, err)
+	assert.NoError(t, d.SetTile([]byte("w"), 1, 2, 3))
+	_, err = manager.Get(2)
+	assert.NoError(t, err)
+	assert.NoError(t, manager.Save())
+
+	assert.NoError(t, manager.Close())
+	assert.NoError(t, manager.Close())
+	_, err = manager.Get(1)
+	assert.Equal(t, db.ErrorManagerClosed, err)
+	assert.Equal(t, db.ErrorManagerClosed, manager.Swap(1, 2))
+	assert.Equal(t, db.ErrorManagerClosed, manager.Save())
+
+	// Every file was released and can be opened again
+	manager = db.NewManager(conf, uuid.NewGenerator())
+	defer manager.Close()
+	d, err = manager.Get(1)
+	assert.NoError(t, err)
+	v, err := d.Tile([]byte("w"), 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(3), v)
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gochunk")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	conf := config.NewConfig()
+	conf.DatabaseLocation = dir
+
+	_, err = db.OpenReadOnly(conf, 0, 10*time.Millisecond)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "manager.db"))
+	assert.True(t, os.IsNotExist(err))
+
+	manager := db.NewManager(conf, uuid.NewGenerator())
+	d, err := manager.Get(1)
+	assert.NoError(t, err)
+	assert.NoError(t, d.SetTile([]byte("w"), 1, 2, 3))
+
+	// A running server holds the files
+	_, err = db.OpenReadOnly(conf, 1, 10*time.Millisecond)
+	assert.Equal(t, db.ErrorDirectoryLocked, err)
+	assert.NoError(t, manager.Close())
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	assert.NoError(t, err)
+	_, err = db.OpenReadOnly(conf, 2, 10*time.Millisecond)
+	assert.Equal(t, db.ErrorIndexNonExistant, err)
+	after, err := filepath.Glob(filepath.Join(dir, "*"))
+	assert.NoError(t, err)
+	assert.Equal(t, files, after)
+
+	d, err = db.OpenReadOnly(conf, 1, 10*time.Millisecond)
+	if assert.NoError(t, err) {
+		v, err := d.Tile([]byte("w"), 1, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, byte(3), v)
+		assert.Error(t, d.SetTile([]byte("w"), 1, 2, 4))
+		assert.NoError(t, d.Close())
+	}
+}
+
+func TestSharedChunkCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gochunk-shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := config.NewConfig()
+	conf.DatabaseLocation = dir
+	conf.ChunkCacheSize = 2 * db.ChunkLength
+	manager := db.NewManager(conf, uuid.NewGenerator())
+	defer manager.Close()
+	w := []byte("w")
+
+	first, err := manager.Get(1)
+	assert.NoError(t, err)
+	second, err := manager.Get(2)
+	assert.NoError(t, err)
+	for _, d := range []db.Database{first, second} {
+		assert.NoError(t, d.SetTile(w, 0, 0, 1))
+		assert.NoError(t, d.SetTile(w, db.ChunkSize, 0, 1))
+	}
+
+	// The same world in two databases is resident separately
+	_, err = first.Tile(w, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]int64{{0, 0}}, first.ResidentChunks(w))
+	assert.Empty(t, second.ResidentChunks(w))
+
+	// Both databases draw on one budget
+	_, err = second.Tile(w, 0, 0)
+	assert.NoError(t, err)
+	_, err = second.Tile(w, db.ChunkSize, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, first.ResidentChunks(w))
+	assert.Equal(t, [][2]int64{{0, 0}, {1, 0}}, second.ResidentChunks(w))
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"errors"
+
+	bbolt "github.com/etcd-io/bbolt"
+)
+
+var (
+	// ErrNoSnapshot is thrown when rolling back to or deleting a snapshot that doesn't exist
+	ErrNoSnapshot = errors.New("no such snapshot")
+
+	snapshotsBucket = []byte("snapshots")
+)
+
+func copyBucket(dst *bbolt.Bucket, src *bbolt.Bucket) error {
+	if src == nil {
+		return nil
+	}
+	return src.ForEach(func(k []byte, v []byte) error {
+		return dst.Put(k, v)
+	})
+}
+
+// Snapshot saves a copy of a world's tiles under name, replacing any snapshot with the same name
+func (d *database) Snapshot(world []byte, name []byte) error {
+	return d.DB.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(snapshotsBucket)
+		if err != nil {
+			return err
+		}
+		b, err = b.CreateBucketIfNotExists(world)
+		if err != nil {
+			return err
+		}
+		if b.Bucket(name) != nil {
+			if err := b.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		snap, err := b.CreateBucket(name)
+		if err != nil {
+			return err
+		}
+		return copyBucket(snap, worldTiles(tx, world))
+	})
+}
+
+// Snapshots lists the names of a world's snapshots in order
+func (d *database) Snapshots(world []byte) ([][]byte, error) {
+	names := [][]byte{}
+	err := d.DB.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(snapshotsBucket)
+		if b != nil {
+			b = b.Bucket(world)
+		}
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k []byte, v []byte) error {
+			names = append(names, append([]byte{}, k...))
+			return nil
+		})
+	})
+	return names, err
+}
+
+// DeleteSnapshot removes a snapshot, freeing the space its copy of the tiles used
+func (d *database) DeleteSnapshot(world []byte, name []byte) error {
+	return d.DB.Update(func(tx *bbolt.Tx) error {
+		snapshots := tx.Bucket(snapshotsBucket)
+		var b *bbolt.Bucket
+		if snapshots != nil {
+			b = snapshots.Bucket(world)
+		}
+		if b == nil || b.Bucket(name) == nil {
+			return ErrNoSnapshot
+		}
+		if err := b.DeleteBucket(name); err != nil {
+			return err
+		}
+		if k, _ := b.Cursor().First(); k == nil {
+			return snapshots.DeleteBucket(world)
+		}
+		return nil
+	})
+}
+
+// Rollback replaces a world's tiles with a snapshot in a single transaction
+func (d *database) Rollback(world []byte, name []byte) error {
+	d.write.Lock()
+	defer d.write.Unlock()
+	err := d.DB.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(snapshotsBucket)
+		if b != nil {
+			b = b.Bucket(world)
+		}
+		if b != nil {
+			b = b.Bucket(name)
+		}
+		if b == nil {
+			return ErrNoSnapshot
+		}
+		if worldTiles(tx, world) != nil {
+			if err := tx.Bucket(tilesBucket).DeleteBucket(world); err != nil {
+				return err
+			}
+		}
+		tiles, err := createWorldTiles(tx, world)
+		if err != nil {
+			return err
+		}
+		return copyBucket(tiles, b)
+	})
+	if err != nil {
+		return err
+	}
+	d.tiles.drop(d.worldID(world))
+	return nil
+}
@@ -0,0 +1,283 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+
+	bbolt "github.com/etcd-io/bbolt"
+)
+
+const (
+	// ChunkShift is the number of bits a tile coordinate is shifted by to get its chunk coordinate
+	ChunkShift = 5
+	// ChunkSize is the width and height of a chunk in tiles
+	ChunkSize = 1 << ChunkShift
+	// ChunkLength is the number of bytes in a chunk's tile data
+	ChunkLength = ChunkSize * ChunkSize
+
+	// MaxFillTiles is the largest number of tiles a single fill may set
+	MaxFillTiles = 1 << 24
+	// MaxFillChunks is the largest number of chunks a single fill may touch, enough for a square of MaxFillTiles
+	MaxFillChunks = MaxFillTiles / ChunkLength
+
+	chunkMask = ChunkSize - 1
+)
+
+var (
+	// ErrInvalidChunk is thrown when stored chunk data is the wrong size
+	ErrInvalidChunk = errors.New("invalid chunk data")
+	// ErrFillTooLarge is thrown when a fill covers more than MaxFillTiles tiles or MaxFillChunks chunks
+	ErrFillTooLarge = errors.New("fill area too large")
+
+	tilesBucket = []byte("tiles")
+)
+
+// ChunkOf returns the chunk coordinates containing the tile
+func ChunkOf(x int64, y int64) (int64, int64) {
+	return x >> ChunkShift, y >> ChunkShift
+}
+
+func chunkKey(cx int64, cy int64) []byte {
+	// Flip the sign bits so chunks sort in coordinate order
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(cx)^(1<<63))
+	binary.BigEndian.PutUint64(key[8:], uint64(cy)^(1<<63))
+	return key
+}
+
+func tileIndex(x int64, y int64) int {
+	return int(y&chunkMask)*ChunkSize + int(x&chunkMask)
+}
+
+func worldTiles(tx *bbolt.Tx, world []byte) *bbolt.Bucket {
+	b := tx.Bucket(tilesBucket)
+	if b == nil {
+		return nil
+	}
+	return b.Bucket(world)
+}
+
+func createWorldTiles(tx *bbolt.Tx, world []byte) (*bbolt.Bucket, error) {
+	b, err := tx.CreateBucketIfNotExists(tilesBucket)
+	if err != nil {
+		return nil, err
+	}
+	return b.CreateBucketIfNotExists(world)
+}
+
+// chunk returns a chunk's tiles, loading it from disk and keeping it resident if needed.
+// The returned slice must not be modified.
+func (d *database) chunk(world []byte, cx int64, cy int64) ([]byte, error) {
+	id := chunkID{worldID: d.worldID(world), cx: cx, cy: cy}
+	data, gen, ok := d.tiles.lookup(id)
+	if ok {
+		return data, nil
+	}
+	err := d.DB.View(func(tx *bbolt.Tx) error {
+		b := worldTiles(tx, world)
+		if b == nil {
+			return nil
+		}
+		v := b.Get(chunkKey(cx, cy))
+		if v == nil {
+			return nil
+		}
+		if len(v) != ChunkLength {
+			return ErrInvalidChunk
+		}
+		data = make([]byte, ChunkLength)
+		copy(data, v)
+		return nil
+	})
+	if err != nil || data == nil {
+		return nil, err
+	}
+	d.tiles.store(id, data, gen)
+	return data, nil
+}
+
+// Tile returns the value of a single tile, unwritten tiles are zero
+func (d *database) Tile(world []byte, x int64, y int64) (byte, error) {
+	cx, cy := ChunkOf(x, y)
+	data, err := d.chunk(world, cx, cy)
+	if err != nil || data == nil {
+		return 0, err
+	}
+	return data[tileIndex(x, y)], nil
+}
+
+// SetTile sets a single tile, creating its chunk if needed
+func (d *database) SetTile(world []byte, x int64, y int64, v byte) error {
+	_, err := d.FillTiles(world, x, y, x, y, v)
+	return err
+}
+
+// FillTiles sets every tile in the inclusive rectangle and returns the number of tiles set
+func (d *database) FillTiles(world []byte, x1 int64, y1 int64, x2 int64, y2 int64, v byte) (int64, error) {
+	if x1 > x2 {
+		x1, x2 = x2, x1
+	}
+	if y1 > y2 {
+		y1, y2 = y2, y1
+	}
+	dx, dy := uint64(x2)-uint64(x1), uint64(y2)-uint64(y1)
+	if dx >= MaxFillTiles || dy >= MaxFillTiles || (dx+1)*(dy+1) > MaxFillTiles {
+		return 0, ErrFillTooLarge
+	}
+	cx1, cy1 := ChunkOf(x1, y1)
+	cx2, cy2 := ChunkOf(x2, y2)
+	// Narrow strips touch a chunk for every few tiles, bound the chunks written in one transaction too
+	if (uint64(cx2)-uint64(cx1)+1)*(uint64(cy2)-uint64(cy1)+1) > MaxFillChunks {
+		return 0, ErrFillTooLarge
+	}
+	d.write.Lock()
+	defer d.write.Unlock()
+	w := d.worldID(world)
+	// Only resident chunks keep their data until the commit, memory doesn't grow with the fill
+	written := make(map[chunkID][]byte)
+	err := d.DB.Update(func(tx *bbolt.Tx) error {
+		b, err := createWorldTiles(tx, world)
+		if err != nil {
+			return err
+		}
+		for cy := cy1; cy <= cy2; cy++ {
+			for cx := cx1; cx <= cx2; cx++ {
+				key := chunkKey(cx, cy)
+				data := make([]byte, ChunkLength)
+				if old := b.Get(key); old != nil {
+					if len(old) != ChunkLength {
+						return ErrInvalidChunk
+					}
+					copy(data, old)
+				}
+				// Clip the rectangle to this chunk
+				sx, ex := cx<<ChunkShift, cx<<ChunkShift+chunkMask
+				sy, ey := cy<<ChunkShift, cy<<ChunkShift+chunkMask
+				if sx < x1 {
+					sx = x1
+				}
+				if ex > x2 {
+					ex = x2
+				}
+				if sy < y1 {
+					sy = y1
+				}
+				if ey > y2 {
+					ey = y2
+				}
+				for y := int64(0); y <= ey-sy; y++ {
+					for x := int64(0); x <= ex-sx; x++ {
+						data[tileIndex(sx+x, sy+y)] = v
+					}
+				}
+				if err := b.Put(key, data); err != nil {
+					return err
+				}
+				id := chunkID{worldID: w, cx: cx, cy: cy}
+				if d.tiles.has(id) {
+					written[id] = data
+				} else {
+					written[id] = nil
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	d.tiles.refresh(w, written)
+	return int64((dx + 1) * (dy + 1)), nil
+}
+
+// TileChunk returns a copy of a chunk's tiles in row-major order, or nil if the chunk was never written
+func (d *database) TileChunk(world []byte, cx int64, cy int64) ([]byte, error) {
+	data, err := d.chunk(world, cx, cy)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	c := make([]byte, ChunkLength)
+	copy(c, data)
+	return c, nil
+}
+
+// TileStats describes a world's tile storage
+type TileStats struct {
+	Chunks         int64
+	ResidentChunks int64
+	ResidentBytes  int64
+}
+
+// TileStats returns the number of stored and resident chunks of a world and the memory they use
+func (d *database) TileStats(world []byte) (TileStats, error) {
+	var stats TileStats
+	err := d.DB.View(func(tx *bbolt.Tx) error {
+		if b := worldTiles(tx, world); b != nil {
+			stats.Chunks = int64(b.Stats().KeyN)
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	stats.ResidentChunks, stats.ResidentBytes = d.tiles.usage(d.worldID(world))
+	return stats, nil
+}
+
+// ResidentChunks lists the coordinates of a world's chunks currently held in memory
+func (d *database) ResidentChunks(world []byte) [][2]int64 {
+	return d.tiles.resident(d.worldID(world))
+}
+
+// ForEachChunk calls fn with a copy of every written chunk of a world in coordinate order
+func (d *database) ForEachChunk(world []byte, fn func(cx int64, cy int64, data []byte) error) error {
+	return d.DB.View(func(tx *bbolt.Tx) error {
+		b := worldTiles(tx, world)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k []byte, v []byte) error {
+			if len(k) != 16 || len(v) != ChunkLength {
+				return ErrInvalidChunk
+			}
+			data := make([]byte, ChunkLength)
+			copy(data, v)
+			cx := int64(binary.BigEndian.Uint64(k[:8]) ^ (1 << 63))
+			cy := int64(binary.BigEndian.Uint64(k[8:]) ^ (1 << 63))
+			return fn(cx, cy, data)
+		})
+	})
+}
+
+// ReplaceTiles replaces all of a world's chunks in a single transaction
+func (d *database) ReplaceTiles(world []byte, chunks map[[2]int64][]byte) error {
+	for _, data := range chunks {
+		if len(data) != ChunkLength {
+			return ErrInvalidChunk
+		}
+	}
+	d.write.Lock()
+	defer d.write.Unlock()
+	err := d.DB.Update(func(tx *bbolt.Tx) error {
+		if worldTiles(tx, world) != nil {
+			if err := tx.Bucket(tilesBucket).DeleteBucket(world); err != nil {
+				return err
+			}
+		}
+		b, err := createWorldTiles(tx, world)
+		if err != nil {
+			return err
+		}
+		for c, data := range chunks {
+			if err := b.Put(chunkKey(c[0], c[1]), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	d.tiles.drop(d.worldID(world))
+	return nil
+}
@@ -0,0 +1,191 @@
+package db_test
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/furui/gochunk/pkg/config"
+	"github.com/furui/gochunk/pkg/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTiles(t *testing.T, budget int64, idle time.Duration) (db.Database, func()) {
+	dir, err := ioutil.TempDir("", "gochunk-tiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := config.NewConfig()
+	conf.DatabaseLocation = dir
+	conf.ChunkCacheSize = budget
+	conf.ChunkIdleTimeout = idle
+	d := db.NewDatabase("tiles", conf)
+	return d, func() {
+		d.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestResidentChunks(t *testing.T) {
+	d, cleanup := setupTiles(t, 2*db.ChunkLength, 0)
+	defer cleanup()
+	w := []byte("w")
+
+	assert.Empty(t, d.ResidentChunks(w))
+	_, err := d.FillTiles(w, 0, 0, db.ChunkSize, db.ChunkSize, 1)
+	assert.NoError(t, err)
+	// Writes don't load chunks, reads do
+	assert.Empty(t, d.ResidentChunks(w))
+	_, err = d.Tile(w, 0, 0)
+	assert.NoError(t, err)
+	_, err = d.Tile(w, db.ChunkSize, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]int64{{0, 0}, {1, 0}}, d.ResidentChunks(w))
+
+	// Over budget, the least recently used chunk is evicted
+	_, err = d.Tile(w, 0, db.ChunkSize)
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]int64{{1, 0}, {0, 1}}, d.ResidentChunks(w))
+
+	// Evicted chunks are still readable and become resident again
+	v, err := d.Tile(w, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(1), v)
+	assert.Equal(t, [][2]int64{{0, 0}, {0, 1}}, d.ResidentChunks(w))
+
+	// Missing chunks are never resident
+	v, err = d.Tile(w, -1, -1)
+	assert.NoError(t, err)
+	assert.Zero(t, v)
+	assert.Len(t, d.ResidentChunks(w), 2)
+	assert.Empty(t, d.ResidentChunks([]byte("other")))
+}
+
+func TestIdleChunks(t *testing.T) {
+	d, cleanup := setupTiles(t, 1<<20, 20*time.Millisecond)
+	defer cleanup()
+	w := []byte("w")
+
+	_, err := d.FillTiles(w, 0, 0, 40, 0, 1)
+	assert.NoError(t, err)
+	_, err = d.Tile(w, 0, 0)
+	assert.NoError(t, err)
+	_, err = d.Tile(w, 40, 0)
+	assert.NoError(t, err)
+	assert.Len(t, d.ResidentChunks(w), 2)
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, d.ResidentChunks(w))
+	data, err := d.TileChunk(w, 1, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(1), data[8])
+	assert.Equal(t, byte(0), data[9])
+}
+
+func TestFillRefreshesResidentChunks(t *testing.T) {
+	d, cleanup := setupTiles(t, 2*db.ChunkLength, 0)
+	defer cleanup()
+	w := []byte("w")
+
+	_, err := d.FillTiles(w, 0, 0, db.ChunkSize, 0, 1)
+	assert.NoError(t, err)
+	_, err = d.Tile(w, 0, 0)
+	assert.NoError(t, err)
+	_, err = d.Tile(w, db.ChunkSize, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]int64{{0, 0}, {1, 0}}, d.ResidentChunks(w))
+
+	// A large fill updates the resident chunks in place without flushing them for the rest
+	_, err = d.FillTiles(w, 0, 0, 16*db.ChunkSize-1, 16*db.ChunkSize-1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]int64{{0, 0}, {1, 0}}, d.ResidentChunks(w))
+	v, err := d.Tile(w, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(2), v)
+	v, err = d.Tile(w, db.ChunkSize, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(2), v)
+}
+
+func TestFillTooLarge(t *testing.T) {
+	d, cleanup := setupTiles(t, 1<<20, 0)
+	defer cleanup()
+	w := []byte("w")
+
+	_, err := d.FillTiles(w, 0, 0, 4096, 4095, 1)
+	assert.Equal(t, db.ErrFillTooLarge, err)
+	// A one tile wide strip is few tiles but touches a chunk every ChunkSize tiles
+	_, err = d.FillTiles(w, 0, 0, 0, db.MaxFillTiles-1, 1)
+	assert.Equal(t, db.ErrFillTooLarge, err)
+	_, err = d.FillTiles(w, 0, 0, 0, db.MaxFillChunks*db.ChunkSize, 1)
+	assert.Equal(t, db.ErrFillTooLarge, err)
+	n, err := d.FillTiles(w, 0, 0, 1, db.MaxFillChunks*db.ChunkSize-1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2*db.MaxFillChunks*db.ChunkSize), n)
+	stats, err := d.TileStats(w)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(db.MaxFillChunks), stats.Chunks)
+}
+
+func TestRollbackDropsResidentChunks(t *testing.T) {
+	d, cleanup := setupTiles(t, 1<<20, 0)
+	defer cleanup()
+	w := []byte("w")
+
+	assert.NoError(t, d.SetTile(w, 1, 1, 1))
+	assert.NoError(t, d.Snapshot(w, []byte("start")))
+	assert.NoError(t, d.SetTile(w, 1, 1, 2))
+	assert.NoError(t, d.SetTile(w, db.ChunkSize, 0, 2))
+	_, err := d.Tile(w, 1, 1)
+	assert.NoError(t, err)
+	_, err = d.Tile(w, db.ChunkSize, 0)
+	assert.NoError(t, err)
+	assert.Len(t, d.ResidentChunks(w), 2)
+
+	assert.NoError(t, d.Rollback(w, []byte("start")))
+	assert.Empty(t, d.ResidentChunks(w))
+	v, err := d.Tile(w, 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(1), v)
+	v, err = d.Tile(w, db.ChunkSize, 0)
+	assert.NoError(t, err)
+	assert.Zero(t, v)
+	assert.Equal(t, [][2]int64{{0, 0}}, d.ResidentChunks(w))
+}
+
+func TestConcurrentTiles(t *testing.T) {
+	d, cleanup := setupTiles(t, 1<<20, 0)
+	defer cleanup()
+	w := []byte("w")
+
+	// Readers loading the chunk race the writers, the last write must win
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(v byte) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				assert.NoError(t, d.SetTile(w, 1, 1, v))
+			}
+		}(byte(i))
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				_, err := d.Tile(w, 1, 1)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.NoError(t, d.SetTile(w, 1, 1, 42))
+	v, err := d.Tile(w, 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(42), v)
+	var stored byte
+	assert.NoError(t, d.ForEachChunk(w, func(cx int64, cy int64, data []byte) error {
+		stored = data[db.ChunkSize+1]
+		return nil
+	}))
+	assert.Equal(t, byte(42), stored)
+}
@@ -58,7 +58,8 @@ func (p *processor) Execute(command string, state state.Client, params [][]byte)
 	if !exist {
 		return nil, fmt.Errorf("unknown command '%s'", command)
 	}
-	if !state.Authenticated() && strings.ToUpper(command) != "AUTH" {
+	// HELLO can authenticate with its AUTH option
+	if !state.Authenticated() && strings.ToUpper(command) != "AUTH" && strings.ToUpper(command) != "HELLO" {
 		return nil, ErrNoAuth
 	}
 	return c.(Command)(p.dbManager, state, params)
@@ -64,6 +64,7 @@ func TestAddExecuteCommand(t *testing.T) {
 	for _, tC := range testCases {
 		t.Run(tC.desc, func(t *testing.T) {
 			state := state.NewClient()
+			state.SetAuthRequired("")
 			b := p.AddCommand(tC.command, tC.fn)
 			assert.True(t, b)
 			tp, err := p.Execute(tC.command, state, tC.data)
@@ -93,7 +94,9 @@ func TestDeleteCommand(t *testing.T) {
 
 func TestExecuteNotFound(t *testing.T) {
 	p := NewProcessor(processorDependencies())
-	res, err := p.Execute("DOESNTEXIST", state.NewClient(), [][]byte{})
+	client := state.NewClient()
+	client.SetAuthRequired("")
+	res, err := p.Execute("DOESNTEXIST", client, [][]byte{})
 	assert.Error(t, err)
 	assert.Nil(t, res)
 }
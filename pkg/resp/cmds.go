@@ -3,7 +3,12 @@ package resp
 import (
 	"errors"
 	"fmt"
+	"log"
+	"net"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/furui/gochunk/pkg/config"
 	"github.com/furui/gochunk/pkg/db"
@@ -15,6 +20,14 @@ import (
 var (
 	// ErrNoMatchingPass is thrown when auth fails
 	ErrNoMatchingPass = errors.New("authentication required")
+	// ErrNoProto is thrown when HELLO asks for a protocol version that isn't supported
+	ErrNoProto = errors.New("NOPROTO unsupported protocol version")
+	// ErrWrongPass is thrown when HELLO AUTH fails
+	ErrWrongPass = errors.New("WRONGPASS invalid username-password pair")
+	// ErrHelloNoAuth is thrown when HELLO is sent by an unauthenticated client without AUTH
+	ErrHelloNoAuth = errors.New("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	// ErrShutdownSave is thrown when SHUTDOWN can't flush the databases
+	ErrShutdownSave = errors.New("Errors trying to SHUTDOWN. Check logs.")
 )
 
 func addAuthCmd(config *config.Config, processor processor.Processor) {
@@ -105,3 +118,133 @@ func addSwapDbCmd(config *config.Config, processor processor.Processor) {
 		return &t, nil
 	})
 }
+
+func addHelloCmd(config *config.Config, processor processor.Processor) {
+	processor.AddCommand("HELLO", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		protocol := state.Protocol()
+		if len(params) > 0 {
+			v, err := strconv.Atoi(string(params[0]))
+			if err != nil || (v != respTypes.RESP2 && v != respTypes.RESP3) {
+				return nil, ErrNoProto
+			}
+			protocol = v
+		}
+		var user, passwd, name []byte
+		for i := 1; i < len(params); i++ {
+			switch strings.ToUpper(string(params[i])) {
+			case "AUTH":
+				if i+2 >= len(params) {
+					return nil, fmt.Errorf("syntax error in HELLO option 'AUTH'")
+				}
+				user, passwd = params[i+1], params[i+2]
+				i += 2
+			case "SETNAME":
+				if i+1 >= len(params) {
+					return nil, fmt.Errorf("syntax error in HELLO option 'SETNAME'")
+				}
+				name = params[i+1]
+				i++
+			default:
+				return nil, fmt.Errorf("syntax error in HELLO option '%s'", params[i])
+			}
+		}
+		if user != nil {
+			// Only the default user exists
+			if string(user) != "default" {
+				return nil, ErrWrongPass
+			}
+			if ok, _ := state.Authenticate(string(passwd)); !ok {
+				return nil, ErrWrongPass
+			}
+		}
+		if !state.Authenticated() {
+			return nil, ErrHelloNoAuth
+		}
+		if name != nil {
+			state.SetName(string(name))
+		}
+		state.SetProtocol(protocol)
+		proto := respTypes.Integer(protocol)
+		return &respTypes.Map{Pairs: []respTypes.MapPair{
+			{Key: &respTypes.BulkString{Data: []byte("server")}, Value: &respTypes.BulkString{Data: []byte("gochunk")}},
+			{Key: &respTypes.BulkString{Data: []byte("proto")}, Value: &proto},
+			{Key: &respTypes.BulkString{Data: []byte("mode")}, Value: &respTypes.BulkString{Data: []byte("standalone")}},
+			{Key: &respTypes.BulkString{Data: []byte("role")}, Value: &respTypes.BulkString{Data: []byte("master")}},
+			{Key: &respTypes.BulkString{Data: []byte("modules")}, Value: &respTypes.Array{Contents: []respTypes.Type{}}},
+		}}, nil
+	})
+}
+
+// serverInfo formats the server section of INFO, with a line per listener
+func serverInfo(s *server) []string {
+	lines := []string{
+		"# Server",
+		"gochunk_mode:standalone",
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		fmt.Sprintf("uptime_in_seconds:%d", int64(s.uptime()/time.Second)),
+	}
+	for i, l := range s.bound() {
+		addr := l.Addr().String()
+		bind := fmt.Sprintf("bind=%s", addr)
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			bind = fmt.Sprintf("bind=%s,port=%s", host, port)
+		}
+		lines = append(lines, fmt.Sprintf("listener%d:name=%s,%s", i, l.name, bind))
+	}
+	return lines
+}
+
+func addInfoCmd(config *config.Config, processor processor.Processor, s *server) {
+	processor.AddCommand("INFO", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		sections := map[string]bool{}
+		for _, p := range params {
+			sections[strings.ToLower(string(p))] = true
+		}
+		lines := []string{}
+		if len(params) == 0 || sections["server"] || sections["default"] || sections["all"] || sections["everything"] {
+			lines = append(lines, serverInfo(s)...)
+		}
+		text := ""
+		if len(lines) > 0 {
+			text = strings.Join(lines, "\r\n") + "\r\n"
+		}
+		return &respTypes.Verbatim{Format: "txt", Data: []byte(text)}, nil
+	})
+}
+
+func addShutdownCmd(config *config.Config, processor processor.Processor, s *server) {
+	processor.AddCommand("SHUTDOWN", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		opts := ShutdownOptions{}
+		save := false
+		for _, p := range params {
+			switch strings.ToUpper(string(p)) {
+			case "NOSAVE":
+				opts.NoSave = true
+			case "SAVE":
+				save = true
+			case "NOW":
+				opts.Now = true
+			case "FORCE":
+				opts.Force = true
+			default:
+				return nil, fmt.Errorf("syntax error")
+			}
+		}
+		if save && opts.NoSave {
+			return nil, fmt.Errorf("syntax error")
+		}
+		if !opts.NoSave {
+			if err := dbManager.Save(); err != nil {
+				log.Printf("couldn't save before shutdown: %s", err)
+				if !opts.Force {
+					return nil, ErrShutdownSave
+				}
+			}
+		}
+		log.Printf("shutdown requested by %s", state.RemoteAddr())
+		s.requestShutdown(opts)
+		// Like Redis the client sees the connection close instead of a reply
+		state.SetClosed(true)
+		return nil, nil
+	})
+}
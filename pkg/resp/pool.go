@@ -2,6 +2,7 @@ package resp
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -9,6 +10,7 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/furui/gochunk/pkg/config"
@@ -30,22 +32,32 @@ var (
 	ErrWrite = errors.New("write failed")
 	// ErrFlush is thrown when a flush fails
 	ErrFlush = errors.New("flush failed")
+	// ErrMaxClients is sent to connections over the client limit
+	ErrMaxClients = errors.New("max number of clients reached")
+	// ErrShutdownTimeout is thrown when connections are still busy once the shutdown timeout passes
+	ErrShutdownTimeout = errors.New("timed out waiting for connections to finish")
 )
 
-// Pool contains a thread pool that handles connections
+// rejectTimeout bounds the write telling a connection the client limit was reached
+const rejectTimeout = time.Second
+
+// Pool serves each connection on its own goroutine while bounding how many commands execute at once
 type Pool interface {
 	Queue(conn net.Conn)
 	Start() error
 	Stop() error
+	Shutdown(timeout time.Duration) error
 }
 
 type pool struct {
 	processor    processor.Processor
-	connections  []net.Conn
-	threads      int
+	connections  map[net.Conn]struct{}
+	executor     chan struct{}
+	maxClients   int
 	started      bool
+	draining     int32
 	mutex        *sync.Mutex
-	cond         *sync.Cond
+	wg           sync.WaitGroup
 	readTimeout  time.Duration
 	writeTimeout time.Duration
 	config       *config.Config
@@ -61,20 +73,34 @@ func (p *pool) Unlock() {
 
 func (p *pool) Queue(conn net.Conn) {
 	p.Lock()
-	p.connections = append(p.connections, conn)
+	if !p.started {
+		p.Unlock()
+		conn.Close()
+		return
+	}
+	if len(p.connections) >= p.maxClients {
+		p.Unlock()
+		reject(conn)
+		return
+	}
+	p.connections[conn] = struct{}{}
+	p.wg.Add(1)
 	p.Unlock()
-	p.cond.Signal()
+	go p.serve(conn)
 }
 
-func (p *pool) dequeue() net.Conn {
-	p.Lock()
-	defer p.Unlock()
-	if len(p.connections) == 0 {
-		return nil
+// reject turns away a connection over the client limit without holding up the accept loop,
+// TLS connections are closed without a reply since writing one would run the handshake
+func reject(conn net.Conn) {
+	defer conn.Close()
+	if _, ok := conn.(*tls.Conn); ok {
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(rejectTimeout))
+	writer := bufio.NewWriter(conn)
+	if e := sendError(writer, ErrMaxClients.Error()); e != nil {
+		log.Printf("couldn't send error to %s: %s", conn.RemoteAddr().String(), e)
 	}
-	c := p.connections[0]
-	p.connections = p.connections[1:]
-	return c
 }
 
 func (p *pool) Start() error {
@@ -84,136 +110,229 @@ func (p *pool) Start() error {
 		return fmt.Errorf("Pool already started")
 	}
 	p.started = true
-	for i := 0; i < p.threads; i++ {
-		go p.thread()
-	}
+	atomic.StoreInt32(&p.draining, 0)
 	return nil
 }
 
 func (p *pool) Stop() error {
 	p.mutex.Lock()
-	defer p.mutex.Unlock()
 	p.started = false
-	return p.kill()
+	err := p.kill()
+	p.mutex.Unlock()
+	p.wg.Wait()
+	return err
+}
+
+// Shutdown stops accepting connections and lets every connection finish the commands it has
+// already received and flush their replies, connections still busy after the timeout are closed
+func (p *pool) Shutdown(timeout time.Duration) error {
+	p.mutex.Lock()
+	p.started = false
+	atomic.StoreInt32(&p.draining, 1)
+	// Idle connections stop waiting for their next command
+	now := time.Now()
+	for c := range p.connections {
+		c.SetReadDeadline(now)
+	}
+	p.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+	}
+	p.mutex.Lock()
+	err := p.kill()
+	p.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return ErrShutdownTimeout
+}
+
+// setReadDeadline gives a connection until the read timeout to send its next command,
+// or no time at all once the pool is shutting down
+func (p *pool) setReadDeadline(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(p.readTimeout))
+	// Shutdown may have expired the deadline before it was set
+	if atomic.LoadInt32(&p.draining) == 1 {
+		conn.SetReadDeadline(time.Now())
+	}
 }
 
 func (p *pool) kill() error {
-	for _, c := range p.connections {
-		err := c.Close()
-		if err != nil {
-			return err
+	var err error
+	for c := range p.connections {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
 		}
 	}
-	return nil
+	return err
 }
 
-func (p *pool) running() (status bool) {
+func (p *pool) remove(conn net.Conn) {
 	p.Lock()
-	status = p.started
+	delete(p.connections, conn)
 	p.Unlock()
-	return
+	conn.Close()
+	p.wg.Done()
 }
 
-func (p *pool) thread() {
-	for p.running() != false {
-		p.Lock()
-		p.cond.Wait()
+// execute runs a command once one of the executor's slots is free
+func (p *pool) execute(cmd string, state state.Client, params [][]byte) (respTypes.Type, error) {
+	p.executor <- struct{}{}
+	defer func() { <-p.executor }()
+	return p.processor.Execute(cmd, state, params)
+}
 
-		p.Unlock()
-		conn := p.dequeue()
-		if conn == nil {
-			continue
+// handshaker is a connection that negotiates before carrying commands
+type handshaker interface {
+	Handshake() error
+}
+
+// flushReader flushes pending replies before blocking on the connection for more input,
+// so a pipeline of commands is answered with as few writes as possible
+type flushReader struct {
+	r io.Reader
+	w *bufio.Writer
+}
+
+func (f *flushReader) Read(b []byte) (int, error) {
+	if f.w.Buffered() > 0 {
+		if err := f.w.Flush(); err != nil {
+			return 0, err
 		}
+	}
+	return f.r.Read(b)
+}
 
-		state := state.NewClient()
-		state.SetAuthRequired(p.config.RequirePass)
-		state.SetRemoteAddr(conn.RemoteAddr().String())
+func (p *pool) serve(conn net.Conn) {
+	defer p.remove(conn)
 
-		scanner := respTypes.NewScanner(conn)
-		writer := bufio.NewWriter(conn)
-		for conn.SetReadDeadline(time.Now().Add(p.readTimeout)); scanner.Scan(); conn.SetReadDeadline(time.Now().Add(p.readTimeout)) {
-			conn.SetWriteDeadline(time.Now().Add(p.writeTimeout))
-			if err := scanner.Err(); err != nil {
-				e := sendError(writer, ErrScan.Error())
-				log.Printf("scan error %s: %s", conn.RemoteAddr().String(), err)
-				if e != nil {
-					log.Printf("couldn't send error to %s: %s", conn.RemoteAddr().String(), e)
-					break
-				}
+	state := state.NewClient()
+	state.SetAuthRequired(p.config.RequirePass)
+
+	// PROXY protocol headers and TLS handshakes are read before any command
+	if h, ok := conn.(handshaker); ok {
+		conn.SetDeadline(time.Now().Add(p.readTimeout))
+		if err := h.Handshake(); err != nil {
+			log.Printf("handshake %s: %s", conn.RemoteAddr().String(), err)
+			return
+		}
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		// A verified client certificate authenticates its common name
+		if user := peerUser(tc); user != "" {
+			state.SetUser(user)
+		}
+	}
+	state.SetRemoteAddr(conn.RemoteAddr().String())
+
+	writer := bufio.NewWriter(conn)
+	defer writer.Flush()
+	scanner := respTypes.NewScanner(&flushReader{r: conn, w: writer})
+	scanner.SetProtocol(state.Protocol())
+	scanner.SetLimits(respTypes.Limits{
+		MaxBulkLen:      p.config.ProtoMaxBulkLen,
+		MaxMultiBulkLen: p.config.MaxMultiBulkLen,
+		MaxInlineLen:    p.config.MaxInlineLen,
+		MaxNesting:      p.config.MaxNesting,
+	})
+	for p.setReadDeadline(conn); scanner.Scan(); p.setReadDeadline(conn) {
+		conn.SetWriteDeadline(time.Now().Add(p.writeTimeout))
+		if err := scanner.Err(); err != nil {
+			if atomic.LoadInt32(&p.draining) == 1 {
 				break
 			}
-			res, ok := scanner.Type().(*respTypes.Array)
-			if !ok {
-				e := sendError(writer, ErrInvalidType.Error())
-				log.Printf("invalid type %s", conn.RemoteAddr().String())
-				if e != nil {
-					log.Printf("couldn't send error to %s: %s", conn.RemoteAddr().String(), e)
-				}
-				if e == io.EOF || e == io.ErrClosedPipe || e == io.ErrUnexpectedEOF {
-					break
-				}
-				continue
+			msg := ErrScan.Error()
+			if _, ok := err.(respTypes.ProtocolError); ok {
+				msg = err.Error()
 			}
-			if len(res.Contents) < 1 {
-				e := sendError(writer, ErrEmptyArray.Error())
-				log.Printf("empty array %s", conn.RemoteAddr().String())
-				if e != nil {
-					log.Printf("couldn't send error to %s: %s", conn.RemoteAddr().String(), e)
-				}
-				if e == io.EOF || e == io.ErrClosedPipe || e == io.ErrUnexpectedEOF {
-					break
-				}
-				continue
+			e := writeError(writer, msg)
+			log.Printf("scan error %s: %s", conn.RemoteAddr().String(), err)
+			if e != nil {
+				log.Printf("couldn't send error to %s: %s", conn.RemoteAddr().String(), e)
+				break
 			}
-			if !containsAllBulkStrings(res.Contents) {
-				e := sendError(writer, ErrInvalidData.Error())
-				log.Printf("invalid data %s", conn.RemoteAddr().String())
-				if e != nil {
-					log.Printf("couldn't send error to %s: %s", conn.RemoteAddr().String(), e)
-				}
-				if e == io.EOF || e == io.ErrClosedPipe || e == io.ErrUnexpectedEOF {
-					break
-				}
-				continue
+			break
+		}
+		res, ok := scanner.Type().(*respTypes.Array)
+		if !ok {
+			e := writeError(writer, ErrInvalidType.Error())
+			log.Printf("invalid type %s", conn.RemoteAddr().String())
+			if e != nil {
+				log.Printf("couldn't send error to %s: %s", conn.RemoteAddr().String(), e)
 			}
-			cmd := string(res.Contents[0].Value().([]byte))
-			params := [][]byte{}
-			for _, v := range res.Contents[1:] {
-				params = append(params, v.Value().([]byte))
+			if e == io.EOF || e == io.ErrClosedPipe || e == io.ErrUnexpectedEOF {
+				break
 			}
-			response, err := p.processor.Execute(strings.ToUpper(cmd), state, params)
-			if err != nil {
-				e := sendError(writer, err.Error())
-				if e != nil {
-					log.Printf("couldn't send error to %s: %s", conn.RemoteAddr().String(), e)
-				}
-				if e == io.EOF || e == io.ErrClosedPipe || e == io.ErrUnexpectedEOF {
-					break
-				}
-				continue
+			continue
+		}
+		if len(res.Contents) < 1 {
+			e := writeError(writer, ErrEmptyArray.Error())
+			log.Printf("empty array %s", conn.RemoteAddr().String())
+			if e != nil {
+				log.Printf("couldn't send error to %s: %s", conn.RemoteAddr().String(), e)
+			}
+			if e == io.EOF || e == io.ErrClosedPipe || e == io.ErrUnexpectedEOF {
+				break
+			}
+			continue
+		}
+		if !containsAllBulkStrings(res.Contents) {
+			e := writeError(writer, ErrInvalidData.Error())
+			log.Printf("invalid data %s", conn.RemoteAddr().String())
+			if e != nil {
+				log.Printf("couldn't send error to %s: %s", conn.RemoteAddr().String(), e)
+			}
+			if e == io.EOF || e == io.ErrClosedPipe || e == io.ErrUnexpectedEOF {
+				break
 			}
+			continue
+		}
+		cmd := string(res.Contents[0].Value().([]byte))
+		params := [][]byte{}
+		for _, v := range res.Contents[1:] {
+			params = append(params, v.Value().([]byte))
+		}
+		response, err := p.execute(strings.ToUpper(cmd), state, params)
+		if err != nil {
+			e := writeError(writer, err.Error())
+			if e != nil {
+				log.Printf("couldn't send error to %s: %s", conn.RemoteAddr().String(), e)
+			}
+			if e == io.EOF || e == io.ErrClosedPipe || e == io.ErrUnexpectedEOF {
+				break
+			}
+			continue
+		}
+		// HELLO may have switched protocols
+		scanner.SetProtocol(state.Protocol())
+		// Commands that close the connection, such as SHUTDOWN, may not reply
+		if response != nil {
+			response = respTypes.Render(response, state.Protocol())
 			if _, err := response.Stream(writer); err != nil {
 				if err == io.EOF || err == io.ErrClosedPipe || err == io.ErrUnexpectedEOF {
 					break
 				}
 				log.Printf("couldn't stream to %s: %s", conn.RemoteAddr().String(), err)
 			}
-			if err := writer.Flush(); err != nil {
-				if err == io.EOF || err == io.ErrClosedPipe || err == io.ErrUnexpectedEOF {
-					break
-				}
-				log.Printf("couldn't flush to %s: %s", conn.RemoteAddr().String(), err)
-			}
-			if state.Closed() == true {
-				break
-			}
-
 		}
-		conn.Close()
+		if state.Closed() == true {
+			break
+		}
+
 	}
 }
 
-// NewPool creates a new thread pool
+// NewPool creates a new connection pool
 func NewPool(config *config.Config, processor processor.Processor) Pool {
 	addAuthCmd(config, processor)
 	addEchoCmd(config, processor)
@@ -221,22 +340,27 @@ func NewPool(config *config.Config, processor processor.Processor) Pool {
 	addSelectCmd(config, processor)
 	addQuitCmd(config, processor)
 	addSwapDbCmd(config, processor)
+	addHelloCmd(config, processor)
 
-	p := &pool{
+	workers := config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	return &pool{
 		processor:    processor,
-		connections:  make([]net.Conn, 0),
-		threads:      config.Workers,
+		connections:  make(map[net.Conn]struct{}),
+		executor:     make(chan struct{}, workers),
+		maxClients:   config.MaxClients,
 		started:      false,
 		mutex:        &sync.Mutex{},
 		readTimeout:  config.ReadTimeout,
 		writeTimeout: config.WriteTimeout,
 		config:       config,
 	}
-	p.cond = sync.NewCond(p)
-	return p
 }
 
-func sendError(w *bufio.Writer, msg string) error {
+// writeError buffers an error reply, it's sent with the next flush
+func writeError(w *bufio.Writer, msg string) error {
 	e := respTypes.Error(msg)
 	b := e.Bytes()
 	nn, err := w.Write(b)
@@ -246,8 +370,14 @@ func sendError(w *bufio.Writer, msg string) error {
 	if nn == 0 {
 		return io.EOF
 	}
-	err = w.Flush()
-	if err != nil {
+	return nil
+}
+
+func sendError(w *bufio.Writer, msg string) error {
+	if err := writeError(w, msg); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
 		return ErrFlush
 	}
 	return nil
@@ -1,6 +1,8 @@
 package resp_test
 
 import (
+	"bytes"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -280,6 +282,163 @@ func TestBuiltIns(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestHello(t *testing.T) {
+	data, p, conf := setupPool()
+	defer data.Close()
+	conf.RequirePass = "secret"
+	err := p.Start()
+	assert.NoError(t, err)
+	buf := make([]byte, 200)
+	s, c := mocks.NewMockConn()
+	p.Queue(s)
+
+	testCases := []struct {
+		desc     string
+		write    []byte
+		response []byte
+	}{
+		{
+			desc:     "unauthenticated",
+			write:    []byte("*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n"),
+			response: []byte("-NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time\r\n"),
+		},
+		{
+			desc:     "unsupported version",
+			write:    []byte("*2\r\n$5\r\nHELLO\r\n$1\r\n4\r\n"),
+			response: []byte("-NOPROTO unsupported protocol version\r\n"),
+		},
+		{
+			desc:     "wrong password",
+			write:    []byte("*5\r\n$5\r\nHELLO\r\n$1\r\n3\r\n$4\r\nAUTH\r\n$7\r\ndefault\r\n$4\r\nnope\r\n"),
+			response: []byte("-WRONGPASS invalid username-password pair\r\n"),
+		},
+		{
+			desc:     "auth and resp2",
+			write:    []byte("*5\r\n$5\r\nHELLO\r\n$1\r\n2\r\n$4\r\nAUTH\r\n$7\r\ndefault\r\n$6\r\nsecret\r\n"),
+			response: []byte("*10\r\n$6\r\nserver\r\n$7\r\ngochunk\r\n$5\r\nproto\r\n:2\r\n$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n"),
+		},
+		{
+			desc:     "resp3",
+			write:    []byte("*4\r\n$5\r\nHELLO\r\n$1\r\n3\r\n$7\r\nSETNAME\r\n$4\r\ntest\r\n"),
+			response: []byte("%5\r\n$6\r\nserver\r\n$7\r\ngochunk\r\n$5\r\nproto\r\n:3\r\n$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n"),
+		},
+		{
+			desc:     "resp3 replies",
+			write:    []byte("*1\r\n$4\r\nPING\r\n"),
+			response: []byte("+PONG\r\n"),
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			c.Write(tC.write)
+			slicebuf := make([]byte, 0)
+			for len(slicebuf) < len(tC.response) {
+				n, err := c.Read(buf)
+				assert.NoError(t, err)
+				slicebuf = append(slicebuf, buf[:n]...)
+			}
+			assert.Equal(t, tC.response, slicebuf)
+		})
+	}
+
+	err = p.Stop()
+	assert.NoError(t, err)
+}
+
+func TestPipelinePartialCommand(t *testing.T) {
+	data, p, _ := setupPool()
+	defer data.Close()
+	err := p.Start()
+	assert.NoError(t, err)
+	s, c := mocks.NewMockConn()
+	p.Queue(s)
+	buf := make([]byte, 50)
+
+	// Replies to complete commands are sent while the rest of the pipeline is in flight
+	c.Write([]byte("*1\r\n$4\r\nPING\r\n*1\r\n$4\r\nPING\r\n*1\r\n$4\r\nPI"))
+	slicebuf := make([]byte, 0)
+	for len(slicebuf) < 14 {
+		n, err := c.Read(buf)
+		assert.NoError(t, err)
+		slicebuf = append(slicebuf, buf[:n]...)
+	}
+	assert.Equal(t, []byte("+PONG\r\n+PONG\r\n"), slicebuf)
+	c.Write([]byte("NG\r\n"))
+	n, err := c.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("+PONG\r\n"), buf[:n])
+
+	err = p.Stop()
+	assert.NoError(t, err)
+}
+
+func TestProtocolLimits(t *testing.T) {
+	data, p, conf := setupPool()
+	defer data.Close()
+	conf.ProtoMaxBulkLen = 16
+	err := p.Start()
+	assert.NoError(t, err)
+	s, c := mocks.NewMockConn()
+	p.Queue(s)
+	buf := make([]byte, 50)
+
+	c.Write([]byte("*2\r\n$4\r\nECHO\r\n$1073741824\r\n"))
+	n, err := c.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("-Protocol error: invalid bulk length\r\n"), buf[:n])
+	_, err = c.Read(buf)
+	assert.Error(t, err)
+
+	err = p.Stop()
+	assert.NoError(t, err)
+}
+
+func TestMaxClients(t *testing.T) {
+	conf := config.NewConfig()
+	conf.ReadTimeout = time.Second
+	conf.DatabaseLocation = os.TempDir()
+	conf.MaxClients = 1
+	data := db.NewManager(conf, uuid.NewGenerator())
+	defer data.Close()
+	p := resp.NewPool(conf, processor.NewProcessor(data))
+	err := p.Start()
+	assert.NoError(t, err)
+	buf := make([]byte, 50)
+
+	s1, c1 := mocks.NewMockConn()
+	p.Queue(s1)
+	c1.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+	n, err := c1.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("+PONG\r\n"), buf[:n])
+
+	s2, c2 := mocks.NewMockConn()
+	p.Queue(s2)
+	n, err = c2.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("-max number of clients reached\r\n"), buf[:n])
+	_, err = c2.Read(buf)
+	assert.Error(t, err)
+
+	// The slot is freed once the first client leaves
+	c1.Write([]byte("*1\r\n$4\r\nQUIT\r\n"))
+	_, err = c1.Read(buf)
+	assert.NoError(t, err)
+	_, err = c1.Read(buf)
+	assert.Error(t, err)
+	s3, c3 := mocks.NewMockConn()
+	p.Queue(s3)
+	c3.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+	n, err = c3.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("+PONG\r\n"), buf[:n])
+
+	err = p.Stop()
+	assert.NoError(t, err)
+	_, err = c3.Read(buf)
+	assert.Error(t, err)
+}
+
 func TestParallel(t *testing.T) {
 	testCases := []struct {
 		desc     string
@@ -330,3 +489,28 @@ func TestParallel(t *testing.T) {
 	wg.Wait()
 	assert.True(t, pass)
 }
+
+func BenchmarkPipeline(b *testing.B) {
+	data, p, _ := setupPool()
+	defer data.Close()
+	if err := p.Start(); err != nil {
+		b.Fatal(err)
+	}
+	defer p.Stop()
+	s, c := mocks.NewMockConn()
+	p.Queue(s)
+
+	const depth = 100
+	request := bytes.Repeat([]byte("*1\r\n$4\r\nPING\r\n"), depth)
+	response := make([]byte, len("+PONG\r\n")*depth)
+	b.SetBytes(int64(len(request)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Write(request); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.ReadFull(c, response); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrProxyHeader is thrown when a connection on a PROXY protocol listener doesn't start with a valid header
+	ErrProxyHeader = errors.New("invalid PROXY protocol header")
+	// ErrProxyTrusted is thrown when a trusted PROXY protocol source isn't an IP address or CIDR
+	ErrProxyTrusted = errors.New("invalid trusted PROXY protocol source, expected an IP address or CIDR")
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	// proxyV1MaxLen is the longest v1 header including the CRLF
+	proxyV1MaxLen    = 107
+	proxyV2HeaderLen = 16
+)
+
+// proxyListener expects a PROXY protocol header on connections from trusted sources,
+// other connections are served with their transport address
+type proxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+func newProxyListener(l net.Listener, trusted []string) (net.Listener, error) {
+	nets := []*net.IPNet{}
+	for _, t := range trusted {
+		if !strings.Contains(t, "/") {
+			ip := net.ParseIP(t)
+			if ip == nil {
+				return nil, fmt.Errorf("%s: %s", ErrProxyTrusted, t)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", ErrProxyTrusted, t)
+		}
+		nets = append(nets, n)
+	}
+	return &proxyListener{Listener: l, trusted: nets}, nil
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trust(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+func (l *proxyListener) trust(addr net.Addr) bool {
+	if len(l.trusted) == 0 {
+		return true
+	}
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.trusted {
+		if n.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn reads the PROXY protocol header before any data and reports the
+// addresses it carries instead of the balancer's
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	once   sync.Once
+	err    error
+	mux    sync.Mutex
+	remote net.Addr
+	local  net.Addr
+}
+
+// Handshake reads the header, it's also read by the first Read
+func (c *proxyConn) Handshake() error {
+	c.once.Do(func() {
+		remote, local, err := readProxyHeader(c.r)
+		if err != nil {
+			c.err = fmt.Errorf("%s: %s", ErrProxyHeader, err)
+			return
+		}
+		c.mux.Lock()
+		c.remote, c.local = remote, local
+		c.mux.Unlock()
+	})
+	return c.err
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if c.r.Buffered() > 0 {
+		return c.r.Read(b)
+	}
+	return c.Conn.Read(b)
+}
+
+// RemoteAddr returns the client's address once the header has been read, or the
+// transport address until then and when the header doesn't carry one
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to from the header
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readProxyHeader parses a v1 or v2 header, addresses are nil for LOCAL and UNKNOWN connections
+func readProxyHeader(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch b[0] {
+	case 'P':
+		return readProxyV1(r)
+	case proxyV2Signature[0]:
+		return readProxyV2(r)
+	}
+	return nil, nil, errors.New("missing header")
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	line := []byte{}
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLen {
+			return nil, nil, errors.New("v1 header too long")
+		}
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, c)
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if fields[0] != "PROXY" || len(fields) < 2 {
+		return nil, nil, errors.New("v1 header must start with PROXY")
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, errors.New("v1 header must be PROXY TCP4|TCP6 src dst sport dport")
+	}
+	src, err := parseProxyV1Addr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseProxyV1Addr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseProxyV1Addr(family string, host string, port string) (net.Addr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || strings.Contains(host, ":") != (family == "TCP6") {
+		return nil, fmt.Errorf("invalid %s address %s", family, host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("invalid port %s", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, proxyV2HeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(header[:len(proxyV2Signature)], proxyV2Signature) {
+		return nil, nil, errors.New("invalid v2 signature")
+	}
+	if header[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("unsupported version %d", header[12]>>4)
+	}
+	data := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, nil, err
+	}
+	switch cmd := header[12] & 0xf; cmd {
+	case 0:
+		// LOCAL connections are health checks from the balancer itself
+		return nil, nil, nil
+	case 1:
+		// PROXY
+	default:
+		return nil, nil, fmt.Errorf("unsupported command %d", cmd)
+	}
+	// Only TCP over IPv4 and IPv6 carry an address we can use, other families keep the transport address
+	switch header[13] {
+	case 0x11:
+		if len(data) < 12 {
+			return nil, nil, errors.New("v2 address block too short")
+		}
+		return &net.TCPAddr{IP: net.IP(data[0:4]), Port: int(binary.BigEndian.Uint16(data[8:10]))},
+			&net.TCPAddr{IP: net.IP(data[4:8]), Port: int(binary.BigEndian.Uint16(data[10:12]))}, nil
+	case 0x21:
+		if len(data) < 36 {
+			return nil, nil, errors.New("v2 address block too short")
+		}
+		return &net.TCPAddr{IP: net.IP(data[0:16]), Port: int(binary.BigEndian.Uint16(data[32:34]))},
+			&net.TCPAddr{IP: net.IP(data[16:32]), Port: int(binary.BigEndian.Uint16(data[34:36]))}, nil
+	}
+	return nil, nil, nil
+}
@@ -0,0 +1,157 @@
+package resp_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/furui/gochunk/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// proxyV2 builds a v2 header, family 0x11 is TCP over IPv4 and 0x21 TCP over IPv6
+func proxyV2(cmd byte, family byte, src net.IP, dst net.IP, sport uint16, dport uint16, tlvs []byte) []byte {
+	b := []byte("\r\n\r\n\x00\r\nQUIT\n")
+	b = append(b, 0x20|cmd, family)
+	addrs := append(append([]byte{}, src...), dst...)
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports[0:2], sport)
+	binary.BigEndian.PutUint16(ports[2:4], dport)
+	addrs = append(append(addrs, ports...), tlvs...)
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(addrs)))
+	return append(append(b, length...), addrs...)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	data, s, _ := setupServer(t, []config.Listener{{Network: "tcp", Address: "127.0.0.1:0", ProxyProtocol: true}})
+	defer data.Close()
+	assert.NoError(t, s.Start())
+	defer s.Stop()
+	addr := s.Addrs()[0]
+
+	src4, dst4 := net.ParseIP("192.0.2.1").To4(), net.ParseIP("192.0.2.2").To4()
+	src6, dst6 := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+	testCases := []struct {
+		desc   string
+		header []byte
+		addr   string
+		fails  bool
+	}{
+		{desc: "v1 tcp4", header: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 5678 3030\r\n"), addr: "192.0.2.1:5678"},
+		{desc: "v1 tcp6", header: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 5678 3030\r\n"), addr: "[2001:db8::1]:5678"},
+		{desc: "v1 unknown", header: []byte("PROXY UNKNOWN ffff:f...f:ffff ffff:f...f:ffff 65535 65535\r\n")},
+		{desc: "v1 unknown short", header: []byte("PROXY UNKNOWN\r\n")},
+		{desc: "v2 tcp4", header: proxyV2(1, 0x11, src4, dst4, 5678, 3030, nil), addr: "192.0.2.1:5678"},
+		{desc: "v2 tcp6", header: proxyV2(1, 0x21, src6, dst6, 5678, 3030, nil), addr: "[2001:db8::1]:5678"},
+		{desc: "v2 tlvs", header: proxyV2(1, 0x11, src4, dst4, 5678, 3030, []byte{0x04, 0x00, 0x01, 0x00}), addr: "192.0.2.1:5678"},
+		{desc: "v2 local", header: proxyV2(0, 0x00, nil, nil, 0, 0, nil)},
+		{desc: "v2 udp", header: proxyV2(1, 0x12, src4, dst4, 5678, 3030, nil)},
+		{desc: "missing", header: []byte{}, fails: true},
+		{desc: "v1 bad family", header: []byte("PROXY UDP4 192.0.2.1 192.0.2.2 5678 3030\r\n"), fails: true},
+		{desc: "v1 mismatched family", header: []byte("PROXY TCP4 2001:db8::1 2001:db8::2 5678 3030\r\n"), fails: true},
+		{desc: "v1 bad port", header: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 65536 3030\r\n"), fails: true},
+		{desc: "v1 leading zero port", header: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 05678 3030\r\n"), fails: true},
+		{desc: "v1 missing field", header: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 5678\r\n"), fails: true},
+		{desc: "v1 bare lf", header: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 5678 3030\n"), fails: true},
+		{desc: "v2 short address", header: proxyV2(1, 0x21, src4, dst4, 5678, 3030, nil), fails: true},
+		{desc: "v2 bad version", header: append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x11, 0x11, 0, 0), fails: true},
+		{desc: "v2 bad command", header: proxyV2(2, 0x11, src4, dst4, 5678, 3030, nil), fails: true},
+		{desc: "v2 bad signature", header: append([]byte("\r\n\r\n\x00\r\nQUIT!"), 0x21, 0x11, 0, 0), fails: true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			conn, err := net.Dial("tcp", addr.String())
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(time.Second))
+			_, err = conn.Write(append(tC.header, []byte("ADDR\r\n")...))
+			assert.NoError(t, err)
+			if tC.fails {
+				_, err = bufio.NewReader(conn).ReadString('\n')
+				assert.Error(t, err)
+				return
+			}
+			want := tC.addr
+			if want == "" {
+				want = conn.LocalAddr().String()
+			}
+			assert.Equal(t, want, send(t, conn, ""))
+		})
+	}
+
+	t.Run("v1 too long", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr.String())
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		long := make([]byte, 200)
+		for i := range long {
+			long[i] = 'x'
+		}
+		_, err = conn.Write(append(append([]byte("PROXY UNKNOWN "), long...), '\r', '\n'))
+		assert.NoError(t, err)
+		_, err = bufio.NewReader(conn).ReadString('\n')
+		assert.Error(t, err)
+	})
+}
+
+func TestProxyProtocolTrusted(t *testing.T) {
+	data, s, _ := setupServer(t, []config.Listener{
+		{Network: "tcp", Address: "127.0.0.1:0", ProxyProtocol: true, ProxyTrusted: []string{"10.0.0.0/8", "192.0.2.1"}},
+		{Network: "tcp", Address: "127.0.0.1:0", ProxyProtocol: true, ProxyTrusted: []string{"127.0.0.0/8"}},
+	})
+	defer data.Close()
+	assert.NoError(t, s.Start())
+	defer s.Stop()
+	addrs := s.Addrs()
+
+	// Untrusted sources are served directly with their own address
+	conn, err := net.Dial("tcp", addrs[0].String())
+	if assert.NoError(t, err) {
+		assert.Equal(t, conn.LocalAddr().String(), send(t, conn, "ADDR\r\n"))
+		conn.Close()
+	}
+	conn, err = net.Dial("tcp", addrs[1].String())
+	if assert.NoError(t, err) {
+		assert.Equal(t, "192.0.2.1:5678", send(t, conn, "PROXY TCP4 192.0.2.1 192.0.2.2 5678 3030\r\nADDR\r\n"))
+		conn.Close()
+	}
+
+	for _, trusted := range []string{"nope", "10.0.0.0/33"} {
+		data, s, _ := setupServer(t, []config.Listener{{Network: "tcp", Address: "127.0.0.1:0", ProxyProtocol: true, ProxyTrusted: []string{trusted}}})
+		assert.Error(t, s.Start(), trusted)
+		assert.Len(t, s.Addrs(), 0)
+		data.Close()
+	}
+}
+
+func TestProxyProtocolTLS(t *testing.T) {
+	_, ca, apply := setupTLS(t)
+	data, s, conf := setupServer(t, []config.Listener{{Network: "tcp", Address: "127.0.0.1:0", ProxyProtocol: true, TLS: true}})
+	defer data.Close()
+	apply(conf)
+	assert.NoError(t, s.Start())
+	defer s.Stop()
+
+	raw, err := net.Dial("tcp", s.Addrs()[0].String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer raw.Close()
+	raw.SetDeadline(time.Now().Add(time.Second))
+	_, err = raw.Write([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 5678 3030\r\n"))
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	conn := tls.Client(raw, &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"})
+	assert.Equal(t, "[2001:db8::1]:5678", send(t, conn, "ADDR\r\n"))
+}
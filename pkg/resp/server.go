@@ -1,73 +1,288 @@
 package resp
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/furui/gochunk/pkg/config"
+	"github.com/furui/gochunk/pkg/processor"
+)
+
+var (
+	// ErrUnknownNetwork is thrown when a listener isn't tcp or unix
+	ErrUnknownNetwork = errors.New("unknown listener network, expected tcp, tcp4, tcp6 or unix")
+	// ErrNoListeners is thrown when the server is started without any listeners
+	ErrNoListeners = errors.New("no listeners configured")
 )
 
 // Server provides a RESP server
 type Server interface {
 	Start() error
 	Stop() error
+	Addrs() []net.Addr
+	ReloadTLS() error
+	Shutdown(timeout time.Duration) error
+	ShutdownRequested() <-chan ShutdownOptions
+}
+
+// ShutdownOptions are the modifiers a SHUTDOWN command was sent with
+type ShutdownOptions struct {
+	// NoSave skips flushing the databases to disk
+	NoSave bool
+	// Now closes connections without waiting for their commands to finish
+	Now bool
+	// Force shuts down even if flushing the databases fails
+	Force bool
+}
+
+// listener is an open listener and the name INFO reports it under
+type listener struct {
+	net.Listener
+	name string
 }
 
 type server struct {
-	config   *config.Config
-	listener net.Listener
-	started  bool
-	pool     Pool
+	config    *config.Config
+	listeners []listener
+	tls       *tlsLoader
+	started   bool
+	startTime time.Time
+	mutex     sync.Mutex
+	wg        sync.WaitGroup
+	pool      Pool
+	shutdown  chan ShutdownOptions
 }
 
-func (s *server) Start() error {
-	if s.started == false {
-		err := s.pool.Start()
+// listen opens a listener, replacing a stale unix socket left behind by a previous run
+func listen(l config.Listener) (net.Listener, error) {
+	switch l.Network {
+	case "tcp", "tcp4", "tcp6":
+		return net.Listen(l.Network, l.Address)
+	case "unix":
+		if fi, err := os.Lstat(l.Address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(l.Address); err != nil {
+				return nil, err
+			}
+		}
+		ln, err := net.Listen("unix", l.Address)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		go func() {
-			l, err := net.Listen("tcp", s.config.Host)
-			if err != nil {
-				panic(err)
+		if l.Perm != 0 {
+			if err := os.Chmod(l.Address, l.Perm); err != nil {
+				ln.Close()
+				return nil, err
 			}
-			defer l.Close()
-			s.listener = l
-			s.started = true
-			for {
-				conn, err := l.Accept()
+		}
+		return ln, nil
+	}
+	return nil, fmt.Errorf("%s: %s", ErrUnknownNetwork, l.Network)
+}
+
+func (s *server) Start() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.started {
+		return fmt.Errorf("Server already started")
+	}
+	if len(s.config.Listeners) == 0 {
+		return ErrNoListeners
+	}
+	if s.tls == nil {
+		for _, l := range s.config.Listeners {
+			if l.TLS {
+				loader, err := newTLSLoader(s.config)
 				if err != nil {
-					log.Fatal(err)
+					return err
 				}
-				if s.started == false {
-					break
+				s.tls = loader
+				break
+			}
+		}
+	}
+	listeners := []listener{}
+	for _, l := range s.config.Listeners {
+		ln, err := listen(l)
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return fmt.Errorf("listen %s %s: %s", l.Network, l.Address, err)
+		}
+		name := ln.Addr().Network()
+		if l.ProxyProtocol {
+			pl, err := newProxyListener(ln, l.ProxyTrusted)
+			if err != nil {
+				ln.Close()
+				for _, ln := range listeners {
+					ln.Close()
 				}
-				s.pool.Queue(conn)
+				return err
 			}
-		}()
-	} else {
-		return fmt.Errorf("Server already started")
+			ln = pl
+		}
+		if l.TLS {
+			ln = tls.NewListener(ln, s.tls.serverConfig())
+			name = "tls"
+		}
+		listeners = append(listeners, listener{Listener: ln, name: name})
+	}
+	if err := s.pool.Start(); err != nil {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+		return err
+	}
+	s.listeners = listeners
+	s.started = true
+	s.startTime = time.Now()
+	for _, ln := range listeners {
+		s.wg.Add(1)
+		go s.accept(ln.Listener)
 	}
 	return nil
 }
 
-func (s *server) Stop() error {
-	if s.started == true {
-		err := s.listener.Close()
+// accept hands every connection on a listener to the shared pool, backing off
+// while the listener is out of resources such as file descriptors
+func (s *server) accept(l net.Listener) {
+	defer s.wg.Done()
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
 		if err != nil {
-			return err
+			if !s.running() {
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				log.Printf("accept %s: %s, retrying in %s", l.Addr(), err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			log.Printf("accept %s: %s, no longer accepting connections", l.Addr(), err)
+			return
 		}
-		err = s.pool.Stop()
-		if err != nil {
-			return err
+		delay = 0
+		s.pool.Queue(conn)
+	}
+}
+
+func (s *server) running() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.started
+}
+
+// closeListeners stops accepting connections, it returns false if the server wasn't started
+func (s *server) closeListeners() (bool, error) {
+	s.mutex.Lock()
+	if !s.started {
+		s.mutex.Unlock()
+		return false, nil
+	}
+	s.started = false
+	var err error
+	for _, ln := range s.listeners {
+		if e := ln.Close(); e != nil && err == nil {
+			err = e
 		}
-		s.started = false
 	}
-	return nil
+	s.listeners = nil
+	s.mutex.Unlock()
+	s.wg.Wait()
+	return true, err
+}
+
+// Stop closes the listeners and every connection straight away
+func (s *server) Stop() error {
+	started, err := s.closeListeners()
+	if !started {
+		return nil
+	}
+	if e := s.pool.Stop(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// Shutdown closes the listeners and gives connections until the timeout to finish their commands
+func (s *server) Shutdown(timeout time.Duration) error {
+	started, err := s.closeListeners()
+	if !started {
+		return nil
+	}
+	if e := s.pool.Shutdown(timeout); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// ShutdownRequested receives the options of a SHUTDOWN command, the owner of the
+// server is expected to shut it down and close the databases
+func (s *server) ShutdownRequested() <-chan ShutdownOptions {
+	return s.shutdown
+}
+
+func (s *server) requestShutdown(opts ShutdownOptions) {
+	select {
+	case s.shutdown <- opts:
+	default:
+		// A shutdown is already pending
+	}
+}
+
+// Addrs returns the addresses the server is listening on
+func (s *server) Addrs() []net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	addrs := []net.Addr{}
+	for _, ln := range s.listeners {
+		addrs = append(addrs, ln.Addr())
+	}
+	return addrs
+}
+
+// ReloadTLS rereads the TLS certificates, new connections use them once loaded
+func (s *server) ReloadTLS() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.tls == nil {
+		return nil
+	}
+	return s.tls.reload()
+}
+
+// bound returns the open listeners
+func (s *server) bound() []listener {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]listener{}, s.listeners...)
+}
+
+func (s *server) uptime() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.started {
+		return 0
+	}
+	return time.Since(s.startTime)
 }
 
 // NewServer returns a new server
-func NewServer(config *config.Config, pool Pool) Server {
-	return &server{config: config, pool: pool, listener: nil, started: false}
+func NewServer(config *config.Config, pool Pool, processor processor.Processor) Server {
+	s := &server{config: config, pool: pool, listeners: nil, started: false, shutdown: make(chan ShutdownOptions, 1)}
+	addInfoCmd(config, processor, s)
+	addShutdownCmd(config, processor, s)
+	return s
 }
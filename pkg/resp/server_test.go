@@ -1 +1,264 @@
 package resp_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/furui/gochunk/pkg/config"
+	"github.com/furui/gochunk/pkg/db"
+	"github.com/furui/gochunk/pkg/processor"
+	"github.com/furui/gochunk/pkg/resp"
+	"github.com/furui/gochunk/pkg/state"
+	respTypes "github.com/furui/gochunk/pkg/types"
+	"github.com/furui/gochunk/pkg/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupServer(t *testing.T, listeners []config.Listener) (db.Manager, resp.Server, *config.Config) {
+	conf := config.NewConfig()
+	conf.ReadTimeout = time.Second
+	dir, err := ioutil.TempDir("", "gochunk")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	conf.DatabaseLocation = dir
+	conf.Listeners = listeners
+
+	data := db.NewManager(conf, uuid.NewGenerator())
+	proc := processor.NewProcessor(data)
+	proc.AddCommand("WHOAMI", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		return &respTypes.BulkString{Data: []byte(state.User())}, nil
+	})
+	proc.AddCommand("SLEEP", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		ms, _ := strconv.Atoi(string(params[0]))
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		t := respTypes.SimpleString("OK")
+		return &t, nil
+	})
+	proc.AddCommand("ADDR", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		return &respTypes.BulkString{Data: []byte(state.RemoteAddr())}, nil
+	})
+	return data, resp.NewServer(conf, resp.NewPool(conf, proc), proc), conf
+}
+
+func roundTrip(t *testing.T, addr net.Addr, cmd string) string {
+	conn, err := net.Dial(addr.Network(), addr.String())
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer conn.Close()
+	return send(t, conn, cmd)
+}
+
+// send writes a command and reads a simple reply or the contents of a bulk reply
+func send(t *testing.T, conn net.Conn, cmd string) string {
+	conn.SetDeadline(time.Now().Add(time.Second))
+	_, err := conn.Write([]byte(cmd))
+	assert.NoError(t, err)
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	assert.NoError(t, err)
+	if !strings.HasPrefix(line, "$") {
+		return line
+	}
+	var n int
+	fmt.Sscanf(line, "$%d", &n)
+	body := make([]byte, n+2)
+	_, err = io.ReadFull(r, body)
+	assert.NoError(t, err)
+	return string(body[:n])
+}
+
+func TestServerListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gochunk")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "gochunk.sock")
+
+	// A socket left behind by a crashed server is replaced
+	stale, err := net.Listen("unix", sock)
+	assert.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listeners := []config.Listener{
+		{Network: "tcp", Address: "127.0.0.1:0"},
+		{Network: "tcp", Address: "127.0.0.1:0"},
+		{Network: "unix", Address: sock, Perm: 0660},
+	}
+	if l, err := net.Listen("tcp6", "[::1]:0"); err == nil {
+		l.Close()
+		listeners = append(listeners, config.Listener{Network: "tcp6", Address: "[::1]:0"})
+	}
+	data, s, _ := setupServer(t, listeners)
+	defer data.Close()
+	assert.NoError(t, s.Start())
+	assert.Error(t, s.Start())
+
+	fi, err := os.Stat(sock)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0660), fi.Mode().Perm())
+
+	addrs := s.Addrs()
+	assert.Len(t, addrs, len(listeners))
+	for _, addr := range addrs {
+		assert.Equal(t, "+PONG\r\n", roundTrip(t, addr, "PING\r\n"), addr.String())
+	}
+
+	info := roundTrip(t, addrs[2], "INFO server\r\n")
+	assert.Contains(t, info, "# Server\r\n")
+	for i, addr := range addrs {
+		_, port, _ := net.SplitHostPort(addr.String())
+		switch addr.Network() {
+		case "unix":
+			assert.Contains(t, info, fmt.Sprintf("listener%d:name=unix,bind=%s\r\n", i, sock))
+		default:
+			assert.Contains(t, info, fmt.Sprintf("listener%d:name=tcp,", i))
+			assert.Contains(t, info, fmt.Sprintf(",port=%s\r\n", port))
+		}
+	}
+	assert.Equal(t, "", roundTrip(t, addrs[0], "INFO keyspace\r\n"))
+
+	assert.NoError(t, s.Stop())
+	assert.NoError(t, s.Stop())
+	assert.Len(t, s.Addrs(), 0)
+	_, err = os.Stat(sock)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestServerListenErrors(t *testing.T) {
+	data, s, _ := setupServer(t, []config.Listener{{Network: "udp", Address: "127.0.0.1:0"}})
+	defer data.Close()
+	assert.Error(t, s.Start())
+
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer busy.Close()
+	data2, s, _ := setupServer(t, []config.Listener{
+		{Network: "tcp", Address: "127.0.0.1:0"},
+		{Network: "tcp", Address: busy.Addr().String()},
+	})
+	defer data2.Close()
+	assert.Error(t, s.Start())
+	assert.Len(t, s.Addrs(), 0)
+
+	data3, s, _ := setupServer(t, []config.Listener{})
+	defer data3.Close()
+	assert.Equal(t, resp.ErrNoListeners, s.Start())
+}
+
+// readClosed checks the server closed the connection without replying
+func readClosed(t *testing.T, conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(make([]byte, 64))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestShutdownCommand(t *testing.T) {
+	data, s, _ := setupServer(t, []config.Listener{{Network: "tcp", Address: "127.0.0.1:0"}})
+	defer data.Close()
+	assert.NoError(t, s.Start())
+	defer s.Stop()
+	addr := s.Addrs()[0]
+
+	assert.Equal(t, "-syntax error\r\n", roundTrip(t, addr, "SHUTDOWN ABORT\r\n"))
+	assert.Equal(t, "-syntax error\r\n", roundTrip(t, addr, "SHUTDOWN SAVE NOSAVE\r\n"))
+	select {
+	case <-s.ShutdownRequested():
+		t.Fatal("shutdown requested by an invalid command")
+	default:
+	}
+
+	conn, err := net.Dial("tcp", addr.String())
+	if assert.NoError(t, err) {
+		_, err = conn.Write([]byte("SHUTDOWN nosave now FORCE\r\n"))
+		assert.NoError(t, err)
+		readClosed(t, conn)
+		conn.Close()
+	}
+	select {
+	case opts := <-s.ShutdownRequested():
+		assert.Equal(t, resp.ShutdownOptions{NoSave: true, Now: true, Force: true}, opts)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown not requested")
+	}
+
+	// Saving fails once the databases are closed, FORCE shuts down anyway
+	assert.NoError(t, data.Close())
+	assert.Equal(t, "-Errors trying to SHUTDOWN. Check logs.\r\n", roundTrip(t, addr, "SHUTDOWN SAVE\r\n"))
+	conn, err = net.Dial("tcp", addr.String())
+	if assert.NoError(t, err) {
+		_, err = conn.Write([]byte("SHUTDOWN FORCE\r\n"))
+		assert.NoError(t, err)
+		readClosed(t, conn)
+		conn.Close()
+	}
+	select {
+	case opts := <-s.ShutdownRequested():
+		assert.Equal(t, resp.ShutdownOptions{Force: true}, opts)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown not requested")
+	}
+}
+
+func TestShutdownDrain(t *testing.T) {
+	data, s, _ := setupServer(t, []config.Listener{{Network: "tcp", Address: "127.0.0.1:0"}})
+	defer data.Close()
+	assert.NoError(t, s.Start())
+	addr := s.Addrs()[0]
+
+	idle, err := net.Dial("tcp", addr.String())
+	assert.NoError(t, err)
+	defer idle.Close()
+	assert.Equal(t, "+PONG\r\n", send(t, idle, "PING\r\n"))
+	busy, err := net.Dial("tcp", addr.String())
+	assert.NoError(t, err)
+	defer busy.Close()
+	_, err = busy.Write([]byte("SLEEP 200\r\nPING\r\n"))
+	assert.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	assert.NoError(t, s.Shutdown(5*time.Second))
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	// Commands received before the shutdown are answered, idle connections are closed without a reply
+	busy.SetReadDeadline(time.Now().Add(time.Second))
+	b, err := ioutil.ReadAll(busy)
+	assert.NoError(t, err)
+	assert.Equal(t, "+OK\r\n+PONG\r\n", string(b))
+	readClosed(t, idle)
+
+	_, err = net.Dial("tcp", addr.String())
+	assert.Error(t, err)
+	assert.NoError(t, s.Shutdown(time.Second))
+	assert.NoError(t, s.Stop())
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	data, s, _ := setupServer(t, []config.Listener{{Network: "tcp", Address: "127.0.0.1:0"}})
+	defer data.Close()
+	assert.NoError(t, s.Start())
+	addr := s.Addrs()[0]
+
+	busy, err := net.Dial("tcp", addr.String())
+	assert.NoError(t, err)
+	defer busy.Close()
+	_, err = busy.Write([]byte("SLEEP 300\r\n"))
+	assert.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	assert.Equal(t, resp.ErrShutdownTimeout, s.Shutdown(50*time.Millisecond))
+	assert.True(t, time.Since(start) < 250*time.Millisecond)
+	readClosed(t, busy)
+}
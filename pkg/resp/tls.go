@@ -0,0 +1,141 @@
+package resp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+
+	"github.com/furui/gochunk/pkg/config"
+)
+
+var (
+	// ErrTLSVersion is thrown when the minimum TLS version isn't 1.0, 1.1, 1.2 or 1.3
+	ErrTLSVersion = errors.New("invalid TLS version, expected 1.0, 1.1, 1.2 or 1.3")
+	// ErrTLSCipher is thrown when a configured cipher suite isn't known
+	ErrTLSCipher = errors.New("unknown TLS cipher suite")
+	// ErrTLSAuthClients is thrown when client authentication isn't no, optional or yes
+	ErrTLSAuthClients = errors.New("invalid TLS client authentication, expected no, optional or yes")
+	// ErrTLSCACert is thrown when the CA file doesn't contain any certificates
+	ErrTLSCACert = errors.New("no certificates found in TLS CA file")
+	// ErrTLSNoCACert is thrown when client authentication is enabled without a CA to verify against
+	ErrTLSNoCACert = errors.New("TLS client authentication requires a CA file")
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsClientAuth = map[string]tls.ClientAuthType{
+	"no":       tls.NoClientCert,
+	"optional": tls.VerifyClientCertIfGiven,
+	"yes":      tls.RequireAndVerifyClientCert,
+}
+
+// tlsLoader reads certificates from the configured files and hands the latest
+// settings to every new handshake, so they can be reloaded without a restart
+type tlsLoader struct {
+	config  *config.Config
+	current atomic.Value
+}
+
+func newTLSLoader(conf *config.Config) (*tlsLoader, error) {
+	l := &tlsLoader{config: conf}
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// reload rereads the certificate files, on error the previous settings are kept
+func (l *tlsLoader) reload() error {
+	c, err := buildTLSConfig(l.config)
+	if err != nil {
+		return err
+	}
+	l.current.Store(c)
+	return nil
+}
+
+// serverConfig returns the config for a TLS listener
+func (l *tlsLoader) serverConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return l.current.Load().(*tls.Config), nil
+		},
+	}
+}
+
+func buildTLSConfig(conf *config.Config) (*tls.Config, error) {
+	version, ok := tlsVersions[conf.TLSMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", ErrTLSVersion, conf.TLSMinVersion)
+	}
+	clientAuth, ok := tlsClientAuth[strings.ToLower(conf.TLSAuthClients)]
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", ErrTLSAuthClients, conf.TLSAuthClients)
+	}
+	ciphers, err := parseCiphers(conf.TLSCiphers)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(conf.TLSCertFile, conf.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	c := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   version,
+		CipherSuites: ciphers,
+		ClientAuth:   clientAuth,
+	}
+	if conf.TLSCACertFile != "" {
+		pem, err := ioutil.ReadFile(conf.TLSCACertFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: %s", ErrTLSCACert, conf.TLSCACertFile)
+		}
+		c.ClientCAs = pool
+	} else if clientAuth != tls.NoClientCert {
+		return nil, ErrTLSNoCACert
+	}
+	return c, nil
+}
+
+// parseCiphers looks up cipher suites by their standard names, TLS 1.3 suites aren't configurable
+func parseCiphers(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	suites := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		suites[s.Name] = s.ID
+	}
+	ids := []uint16{}
+	for _, name := range names {
+		id, ok := suites[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("%s: %s", ErrTLSCipher, name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// peerUser returns the common name of a verified client certificate
+func peerUser(conn *tls.Conn) string {
+	certs := conn.ConnectionState().VerifiedChains
+	if len(certs) == 0 || len(certs[0]) == 0 {
+		return ""
+	}
+	return certs[0][0].Subject.CommonName
+}
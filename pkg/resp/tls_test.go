@@ -0,0 +1,278 @@
+package resp_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/furui/gochunk/pkg/config"
+	"github.com/furui/gochunk/pkg/processor"
+	"github.com/furui/gochunk/pkg/resp"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pair tls.Certificate
+}
+
+// newCert signs a certificate with the parent, or self-signs a CA when there's no parent
+func newCert(t *testing.T, cn string, serial int64, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testCert{cert: cert, key: key, pair: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}}
+}
+
+func (c *testCert) write(t *testing.T, certFile string, keyFile string) {
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	if keyFile == "" {
+		return
+	}
+	der, err := x509.MarshalECPrivateKey(c.key)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+}
+
+func setupTLS(t *testing.T) (string, *testCert, func(*config.Config)) {
+	dir, err := ioutil.TempDir("", "gochunk")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	ca := newCert(t, "gochunk test CA", 1, nil)
+	ca.write(t, filepath.Join(dir, "ca.crt"), "")
+	newCert(t, "server", 2, ca).write(t, filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"))
+	apply := func(conf *config.Config) {
+		conf.TLSCertFile = filepath.Join(dir, "server.crt")
+		conf.TLSKeyFile = filepath.Join(dir, "server.key")
+		conf.TLSCACertFile = filepath.Join(dir, "ca.crt")
+	}
+	return dir, ca, apply
+}
+
+func dialTLS(t *testing.T, addr net.Addr, ca *testCert, client *testCert, maxVersion uint16) (*tls.Conn, error) {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	c := &tls.Config{RootCAs: roots, MaxVersion: maxVersion}
+	if client != nil {
+		// Send the certificate even when it isn't signed by a CA the server asks for
+		c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &client.pair, nil
+		}
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", addr.String(), c)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(time.Second))
+	// TLS 1.3 reports a rejected client certificate on the first read
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func TestTLSListener(t *testing.T) {
+	_, ca, apply := setupTLS(t)
+	data, s, conf := setupServer(t, []config.Listener{
+		{Network: "tcp", Address: "127.0.0.1:0"},
+		{Network: "tcp", Address: "127.0.0.1:0", TLS: true},
+	})
+	defer data.Close()
+	apply(conf)
+	conf.RequirePass = "secret"
+	conf.TLSAuthClients = "optional"
+	assert.NoError(t, s.Start())
+	defer s.Stop()
+	addrs := s.Addrs()
+
+	assert.Equal(t, "-authentication required\r\n", roundTrip(t, addrs[0], "PING\r\n"))
+
+	// Without a client certificate the password is still required
+	conn, err := dialTLS(t, addrs[1], ca, nil, 0)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "-authentication required\r\n", send(t, conn, "PING\r\n"))
+		conn.Close()
+	}
+
+	// A verified client certificate authenticates its common name
+	conn, err = dialTLS(t, addrs[1], ca, newCert(t, "alice", 3, ca), 0)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "+PONG\r\n", send(t, conn, "PING\r\n"))
+		assert.Equal(t, "alice", send(t, conn, "WHOAMI\r\n"))
+		assert.Contains(t, send(t, conn, "INFO\r\n"), "listener1:name=tls,bind=127.0.0.1,")
+		conn.Close()
+	}
+
+	// A certificate from another CA is rejected
+	other := newCert(t, "other CA", 4, nil)
+	conn, err = dialTLS(t, addrs[1], ca, newCert(t, "mallory", 5, other), 0)
+	if err == nil {
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	assert.Error(t, err)
+}
+
+func TestTLSRequireClientCert(t *testing.T) {
+	_, ca, apply := setupTLS(t)
+	data, s, conf := setupServer(t, []config.Listener{{Network: "tcp", Address: "127.0.0.1:0", TLS: true}})
+	defer data.Close()
+	apply(conf)
+	conf.TLSAuthClients = "yes"
+	conf.TLSMinVersion = "1.3"
+	assert.NoError(t, s.Start())
+	defer s.Stop()
+	addr := s.Addrs()[0]
+
+	conn, err := dialTLS(t, addr, ca, nil, 0)
+	if err == nil {
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	assert.Error(t, err)
+
+	_, err = dialTLS(t, addr, ca, newCert(t, "bob", 3, ca), tls.VersionTLS12)
+	assert.Error(t, err)
+
+	conn, err = dialTLS(t, addr, ca, newCert(t, "bob", 3, ca), 0)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "bob", send(t, conn, "WHOAMI\r\n"))
+		conn.Close()
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	dir, ca, apply := setupTLS(t)
+	data, s, conf := setupServer(t, []config.Listener{{Network: "tcp", Address: "127.0.0.1:0", TLS: true}})
+	defer data.Close()
+	apply(conf)
+	assert.NoError(t, s.Start())
+	defer s.Stop()
+	addr := s.Addrs()[0]
+
+	conn, err := dialTLS(t, addr, ca, nil, 0)
+	if assert.NoError(t, err) {
+		assert.Equal(t, big.NewInt(2), conn.ConnectionState().PeerCertificates[0].SerialNumber)
+		conn.Close()
+	}
+
+	newCert(t, "server", 6, ca).write(t, filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"))
+	assert.NoError(t, s.ReloadTLS())
+	conn, err = dialTLS(t, addr, ca, nil, 0)
+	if assert.NoError(t, err) {
+		assert.Equal(t, big.NewInt(6), conn.ConnectionState().PeerCertificates[0].SerialNumber)
+		conn.Close()
+	}
+
+	// A broken certificate keeps the previous one in use
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "server.crt"), []byte("garbage"), 0600))
+	assert.Error(t, s.ReloadTLS())
+	conn, err = dialTLS(t, addr, ca, nil, 0)
+	if assert.NoError(t, err) {
+		assert.Equal(t, big.NewInt(6), conn.ConnectionState().PeerCertificates[0].SerialNumber)
+		conn.Close()
+	}
+}
+
+func TestTLSMaxClients(t *testing.T) {
+	_, ca, apply := setupTLS(t)
+	data, _, conf := setupServer(t, []config.Listener{{Network: "tcp", Address: "127.0.0.1:0", TLS: true}})
+	defer data.Close()
+	apply(conf)
+	conf.MaxClients = 1
+	proc := processor.NewProcessor(data)
+	s := resp.NewServer(conf, resp.NewPool(conf, proc), proc)
+	assert.NoError(t, s.Start())
+	defer s.Stop()
+	addr := s.Addrs()[0]
+
+	conn, err := dialTLS(t, addr, ca, nil, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	assert.Equal(t, "+PONG\r\n", send(t, conn, "PING\r\n"))
+
+	// Connections over the limit that never send a ClientHello are closed without holding up the listener
+	for i := 0; i < 2; i++ {
+		raw, err := net.Dial("tcp", addr.String())
+		if !assert.NoError(t, err) {
+			return
+		}
+		raw.SetDeadline(time.Now().Add(time.Second))
+		_, err = raw.Read(make([]byte, 1))
+		assert.Equal(t, io.EOF, err)
+		raw.Close()
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	testCases := []struct {
+		desc string
+		fn   func(*config.Config)
+	}{
+		{desc: "version", fn: func(c *config.Config) { c.TLSMinVersion = "2.0" }},
+		{desc: "cipher", fn: func(c *config.Config) { c.TLSCiphers = []string{"TLS_NOPE"} }},
+		{desc: "auth clients", fn: func(c *config.Config) { c.TLSAuthClients = "maybe" }},
+		{desc: "missing cert", fn: func(c *config.Config) { c.TLSCertFile = "/nonexistent" }},
+		{desc: "no ca", fn: func(c *config.Config) { c.TLSAuthClients = "yes"; c.TLSCACertFile = "" }},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			_, _, apply := setupTLS(t)
+			data, s, conf := setupServer(t, []config.Listener{{Network: "tcp", Address: "127.0.0.1:0", TLS: true}})
+			defer data.Close()
+			apply(conf)
+			tC.fn(conf)
+			assert.Error(t, s.Start())
+			assert.Len(t, s.Addrs(), 0)
+		})
+	}
+
+	_, ca, apply := setupTLS(t)
+	data, s, conf := setupServer(t, []config.Listener{{Network: "tcp", Address: "127.0.0.1:0", TLS: true}})
+	defer data.Close()
+	apply(conf)
+	conf.TLSMinVersion = "1.2"
+	conf.TLSCiphers = []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}
+	assert.NoError(t, s.Start())
+	defer s.Stop()
+	conn, err := dialTLS(t, s.Addrs()[0], ca, nil, tls.VersionTLS12)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, conn.ConnectionState().CipherSuite)
+		conn.Close()
+	}
+}
@@ -18,6 +18,12 @@ type Client interface {
 	SetAuthRequired(string)
 	SetRemoteAddr(string)
 	RemoteAddr() string
+	Protocol() int
+	SetProtocol(int)
+	Name() string
+	SetName(string)
+	User() string
+	SetUser(string)
 }
 
 type client struct {
@@ -26,6 +32,35 @@ type client struct {
 	authed       bool
 	authRequired string
 	remoteAddr   string
+	protocol     int
+	name         string
+	user         string
+}
+
+func (s *client) Protocol() int {
+	return s.protocol
+}
+
+func (s *client) SetProtocol(protocol int) {
+	s.protocol = protocol
+}
+
+func (s *client) Name() string {
+	return s.name
+}
+
+func (s *client) SetName(name string) {
+	s.name = name
+}
+
+func (s *client) User() string {
+	return s.user
+}
+
+// SetUser authenticates the client as a user identified outside of AUTH, such as by a client certificate
+func (s *client) SetUser(user string) {
+	s.user = user
+	s.authed = true
 }
 
 func (s *client) SetRemoteAddr(addr string) {
@@ -83,5 +118,7 @@ func NewClient() Client {
 	return &client{
 		database: 0,
 		closed:   false,
+		authed:   false,
+		protocol: 2,
 	}
 }
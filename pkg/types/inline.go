@@ -0,0 +1,120 @@
+package types
+
+import (
+	"bytes"
+)
+
+var (
+	// ErrUnbalancedQuotes is thrown when an inline command has an unterminated or misplaced quote
+	ErrUnbalancedQuotes = ProtocolError("unbalanced quotes in request")
+)
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\v' || c == '\f' || c == '\r'
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexDigitToInt(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
+
+// SplitArgs splits an inline command or a config file line into arguments the same way as Redis' sdssplitargs.
+// Arguments are separated by whitespace and may be double quoted, with \xHH, \n, \r, \t,
+// \b and \a escapes, or single quoted, where only \' is an escape. A closing quote must be
+// followed by whitespace or the end of the line.
+func SplitArgs(line []byte) ([][]byte, error) {
+	// Redis parses a C string, nothing after a NUL is seen
+	if i := bytes.IndexByte(line, 0); i >= 0 {
+		line = line[:i]
+	}
+	args := [][]byte{}
+	p := 0
+	for {
+		for p < len(line) && isSpace(line[p]) {
+			p++
+		}
+		if p >= len(line) {
+			return args, nil
+		}
+		inq, insq, done := false, false, false
+		current := []byte{}
+		for !done {
+			switch {
+			case inq:
+				switch {
+				case p >= len(line):
+					return nil, ErrUnbalancedQuotes
+				case line[p] == '\\' && p+3 < len(line) && line[p+1] == 'x' && isHexDigit(line[p+2]) && isHexDigit(line[p+3]):
+					current = append(current, hexDigitToInt(line[p+2])*16+hexDigitToInt(line[p+3]))
+					p += 3
+				case line[p] == '\\' && p+1 < len(line):
+					p++
+					c := line[p]
+					switch c {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					case 'b':
+						c = '\b'
+					case 'a':
+						c = '\a'
+					}
+					current = append(current, c)
+				case line[p] == '"':
+					if p+1 < len(line) && !isSpace(line[p+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				default:
+					current = append(current, line[p])
+				}
+			case insq:
+				switch {
+				case p >= len(line):
+					return nil, ErrUnbalancedQuotes
+				case line[p] == '\\' && p+1 < len(line) && line[p+1] == '\'':
+					p++
+					current = append(current, '\'')
+				case line[p] == '\'':
+					if p+1 < len(line) && !isSpace(line[p+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				default:
+					current = append(current, line[p])
+				}
+			default:
+				if p >= len(line) {
+					done = true
+					break
+				}
+				switch line[p] {
+				case ' ', '\n', '\r', '\t':
+					done = true
+				case '"':
+					inq = true
+				case '\'':
+					insq = true
+				default:
+					current = append(current, line[p])
+				}
+			}
+			if p < len(line) {
+				p++
+			}
+		}
+		args = append(args, current)
+	}
+}
@@ -0,0 +1,69 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func args(a ...string) [][]byte {
+	b := [][]byte{}
+	for _, s := range a {
+		b = append(b, []byte(s))
+	}
+	return b
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    [][]byte
+		wantErr bool
+	}{
+		{name: "empty", line: "", want: args()},
+		{name: "only spaces", line: " \t  ", want: args()},
+		{name: "simple", line: "set key value", want: args("set", "key", "value")},
+		{name: "multiple spaces", line: "  set   key \t value  ", want: args("set", "key", "value")},
+		{name: "vertical tab and form feed separate", line: "a\vb\fc", want: args("a\vb\fc")},
+		{name: "double quotes", line: `"set" "key" "value"`, want: args("set", "key", "value")},
+		{name: "double quotes with spaces", line: `set "key with space" value`, want: args("set", "key with space", "value")},
+		{name: "single quotes with spaces", line: `set 'single quoted' v`, want: args("set", "single quoted", "v")},
+		{name: "empty double quotes", line: `set "" v`, want: args("set", "", "v")},
+		{name: "empty single quotes", line: `set '' v`, want: args("set", "", "v")},
+		{name: "hex escape", line: `"\x41\x62\xff"`, want: args("Ab\xff")},
+		{name: "hex escape uppercase", line: `"\xFF\x0A"`, want: args("\xff\n")},
+		{name: "short hex escape", line: `"\x4"`, want: args("x4")},
+		{name: "invalid hex escape", line: `"\xzz"`, want: args("xzz")},
+		{name: "control escapes", line: `"a\nb\rc\td\be\af"`, want: args("a\nb\rc\td\be\af")},
+		{name: "escaped quote", line: `"\"quoted\""`, want: args(`"quoted"`)},
+		{name: "escaped backslash", line: `"a\\b"`, want: args(`a\b`)},
+		{name: "unknown escape", line: `"\q"`, want: args("q")},
+		{name: "single quote escape", line: `'it\'s'`, want: args("it's")},
+		{name: "single quotes keep backslashes", line: `'a\nb\x41'`, want: args(`a\nb\x41`)},
+		{name: "unquoted backslashes", line: `foo\x41 \n`, want: args(`foo\x41`, `\n`)},
+		{name: "quote inside token", line: `foo"bar baz"`, want: args("foobar baz")},
+		{name: "single quote inside token", line: `foo'bar baz'`, want: args("foobar baz")},
+		{name: "closing quote then tab", line: "\"a\"\tb", want: args("a", "b")},
+		{name: "nul ends the line", line: "set\x00key value", want: args("set")},
+		{name: "unterminated double quotes", line: `set "key`, wantErr: true},
+		{name: "unterminated single quotes", line: `set 'key`, wantErr: true},
+		{name: "escaped closing quote", line: `"end\"`, wantErr: true},
+		{name: "trailing backslash", line: `"end\`, wantErr: true},
+		{name: "text after double quote", line: `"foo"bar`, wantErr: true},
+		{name: "text after single quote", line: `'foo'bar`, wantErr: true},
+		{name: "quote after double quote", line: `"foo""bar"`, wantErr: true},
+		{name: "nul inside quotes", line: "\"foo\x00\"", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitArgs([]byte(tt.line))
+			if tt.wantErr {
+				assert.Equal(t, ErrUnbalancedQuotes, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
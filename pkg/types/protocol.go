@@ -0,0 +1,91 @@
+package types
+
+import (
+	"bytes"
+)
+
+const (
+	// RESP2 is the original protocol version
+	RESP2 = 2
+	// RESP3 is the protocol version negotiated with HELLO 3
+	RESP3 = 3
+)
+
+// Render converts a reply into the types a client speaking the protocol version understands.
+// RESP3 only types are downgraded for RESP2 clients and RESP2 null bulk strings become nulls for RESP3.
+func Render(t Type, protocol int) Type {
+	if protocol == RESP3 {
+		return renderRESP3(t)
+	}
+	return renderRESP2(t)
+}
+
+func renderAll(contents []Type, fn func(Type) Type) []Type {
+	out := make([]Type, len(contents))
+	for i, t := range contents {
+		out[i] = fn(t)
+	}
+	return out
+}
+
+func renderPairs(pairs []MapPair, fn func(Type) Type) []MapPair {
+	out := make([]MapPair, len(pairs))
+	for i, p := range pairs {
+		out[i] = MapPair{Key: fn(p.Key), Value: fn(p.Value)}
+	}
+	return out
+}
+
+func renderRESP2(t Type) Type {
+	switch v := t.(type) {
+	case *Array:
+		return &Array{Contents: renderAll(v.Contents, renderRESP2)}
+	case *Map:
+		return &Array{Contents: renderAll(flattenPairs(v.Pairs), renderRESP2)}
+	case *Set:
+		return &Array{Contents: renderAll(v.Contents, renderRESP2)}
+	case *Push:
+		return &Array{Contents: renderAll(v.Contents, renderRESP2)}
+	case *Attribute:
+		return renderRESP2(v.Reply)
+	case *Double:
+		return &BulkString{Data: []byte(v.String())}
+	case *Boolean:
+		i := Integer(0)
+		if *v {
+			i = 1
+		}
+		return &i
+	case *Null:
+		return &BulkString{Data: nil}
+	case *BigNumber:
+		return &BulkString{Data: []byte(v.Int.String())}
+	case *Verbatim:
+		return &BulkString{Data: v.Data}
+	case *BlobError:
+		// Simple errors can't contain line breaks
+		e := Error(bytes.Replace(bytes.Replace(v.Data, []byte("\r"), []byte(" "), -1), []byte("\n"), []byte(" "), -1))
+		return &e
+	}
+	return t
+}
+
+func renderRESP3(t Type) Type {
+	switch v := t.(type) {
+	case *BulkString:
+		if v.Data == nil {
+			return &Null{}
+		}
+	case *Array:
+		return &Array{Contents: renderAll(v.Contents, renderRESP3)}
+	case *Map:
+		return &Map{Pairs: renderPairs(v.Pairs, renderRESP3)}
+	case *Set:
+		return &Set{Contents: renderAll(v.Contents, renderRESP3)}
+	case *Push:
+		return &Push{Contents: renderAll(v.Contents, renderRESP3)}
+	case *Attribute:
+		return &Attribute{Pairs: renderPairs(v.Pairs, renderRESP3), Reply: renderRESP3(v.Reply)}
+	}
+	return t
+}
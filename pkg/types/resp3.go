@@ -0,0 +1,298 @@
+package types
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// streamAggregate writes an aggregate header followed by each of its elements
+func streamAggregate(w *bufio.Writer, prefix byte, contents []Type) (int, error) {
+	total, err := w.Write([]byte(fmt.Sprintf("%c%d\r\n", prefix, len(contents))))
+	if err != nil {
+		return total, err
+	}
+	for _, t := range contents {
+		nn, err := t.Stream(w)
+		if err != nil {
+			return total + nn, err
+		}
+		total += nn
+	}
+	return total, nil
+}
+
+func aggregateBytes(prefix byte, contents []Type) []byte {
+	output := []byte(fmt.Sprintf("%c%d\r\n", prefix, len(contents)))
+	for _, t := range contents {
+		output = append(output, t.Bytes()...)
+	}
+	return output
+}
+
+// MapPair is a key and value in a RESP3 map or attribute
+type MapPair struct {
+	Key   Type
+	Value Type
+}
+
+func flattenPairs(pairs []MapPair) []Type {
+	contents := make([]Type, 0, len(pairs)*2)
+	for _, p := range pairs {
+		contents = append(contents, p.Key, p.Value)
+	}
+	return contents
+}
+
+func pairsBytes(prefix byte, pairs []MapPair) []byte {
+	output := []byte(fmt.Sprintf("%c%d\r\n", prefix, len(pairs)))
+	for _, p := range pairs {
+		output = append(output, p.Key.Bytes()...)
+		output = append(output, p.Value.Bytes()...)
+	}
+	return output
+}
+
+func streamPairs(w *bufio.Writer, prefix byte, pairs []MapPair) (int, error) {
+	total, err := w.Write([]byte(fmt.Sprintf("%c%d\r\n", prefix, len(pairs))))
+	if err != nil {
+		return total, err
+	}
+	for _, p := range pairs {
+		nn, err := p.Key.Stream(w)
+		total += nn
+		if err != nil {
+			return total, err
+		}
+		nn, err = p.Value.Stream(w)
+		total += nn
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Map is a RESP3 map type
+type Map struct {
+	Pairs []MapPair
+}
+
+// Bytes returns the bytes representation
+func (m *Map) Bytes() []byte {
+	return pairsBytes('%', m.Pairs)
+}
+
+// Stream the bytes to the writer
+func (m *Map) Stream(w *bufio.Writer) (int, error) {
+	return streamPairs(w, '%', m.Pairs)
+}
+
+// Value of the type
+func (m *Map) Value() interface{} {
+	return m.Pairs
+}
+
+// Set is a RESP3 set type
+type Set struct {
+	Contents []Type
+}
+
+// Bytes returns the bytes representation
+func (s *Set) Bytes() []byte {
+	return aggregateBytes('~', s.Contents)
+}
+
+// Stream the bytes to the writer
+func (s *Set) Stream(w *bufio.Writer) (int, error) {
+	return streamAggregate(w, '~', s.Contents)
+}
+
+// Value of the type
+func (s *Set) Value() interface{} {
+	return s.Contents
+}
+
+// Push is a RESP3 out of band push type
+type Push struct {
+	Contents []Type
+}
+
+// Bytes returns the bytes representation
+func (p *Push) Bytes() []byte {
+	return aggregateBytes('>', p.Contents)
+}
+
+// Stream the bytes to the writer
+func (p *Push) Stream(w *bufio.Writer) (int, error) {
+	return streamAggregate(w, '>', p.Contents)
+}
+
+// Value of the type
+func (p *Push) Value() interface{} {
+	return p.Contents
+}
+
+// Attribute is a RESP3 attribute type, auxiliary pairs sent ahead of a reply
+type Attribute struct {
+	Pairs []MapPair
+	Reply Type
+}
+
+// Bytes returns the bytes representation
+func (a *Attribute) Bytes() []byte {
+	return append(pairsBytes('|', a.Pairs), a.Reply.Bytes()...)
+}
+
+// Stream the bytes to the writer
+func (a *Attribute) Stream(w *bufio.Writer) (int, error) {
+	total, err := streamPairs(w, '|', a.Pairs)
+	if err != nil {
+		return total, err
+	}
+	nn, err := a.Reply.Stream(w)
+	return total + nn, err
+}
+
+// Value of the reply the attribute is attached to
+func (a *Attribute) Value() interface{} {
+	return a.Reply.Value()
+}
+
+// Double is a RESP3 floating point type
+type Double float64
+
+func (d *Double) String() string {
+	f := float64(*d)
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Bytes returns the bytes representation
+func (d *Double) Bytes() []byte {
+	return []byte(fmt.Sprintf(",%s\r\n", d.String()))
+}
+
+// Stream the bytes to the writer
+func (d *Double) Stream(w *bufio.Writer) (int, error) {
+	return w.Write(d.Bytes())
+}
+
+// Value of the type
+func (d *Double) Value() interface{} {
+	return float64(*d)
+}
+
+// Boolean is a RESP3 boolean type
+type Boolean bool
+
+// Bytes returns the bytes representation
+func (b *Boolean) Bytes() []byte {
+	if *b {
+		return []byte("#t\r\n")
+	}
+	return []byte("#f\r\n")
+}
+
+// Stream the bytes to the writer
+func (b *Boolean) Stream(w *bufio.Writer) (int, error) {
+	return w.Write(b.Bytes())
+}
+
+// Value of the type
+func (b *Boolean) Value() interface{} {
+	return bool(*b)
+}
+
+// Null is the RESP3 null type
+type Null struct{}
+
+// Bytes returns the bytes representation
+func (n *Null) Bytes() []byte {
+	return []byte("_\r\n")
+}
+
+// Stream the bytes to the writer
+func (n *Null) Stream(w *bufio.Writer) (int, error) {
+	return w.Write(n.Bytes())
+}
+
+// Value of the type
+func (n *Null) Value() interface{} {
+	return nil
+}
+
+// BigNumber is a RESP3 arbitrary precision integer type
+type BigNumber struct {
+	Int *big.Int
+}
+
+// Bytes returns the bytes representation
+func (b *BigNumber) Bytes() []byte {
+	return []byte(fmt.Sprintf("(%s\r\n", b.Int.String()))
+}
+
+// Stream the bytes to the writer
+func (b *BigNumber) Stream(w *bufio.Writer) (int, error) {
+	return w.Write(b.Bytes())
+}
+
+// Value of the type
+func (b *BigNumber) Value() interface{} {
+	return b.Int
+}
+
+// Verbatim is a RESP3 verbatim string type with a three letter format such as txt or mkd
+type Verbatim struct {
+	Format string
+	Data   []byte
+}
+
+// Bytes returns the bytes representation
+func (v *Verbatim) Bytes() []byte {
+	return []byte(fmt.Sprintf("=%d\r\n%s:%s\r\n", len(v.Data)+4, v.Format, v.Data))
+}
+
+// Stream the bytes to the writer
+func (v *Verbatim) Stream(w *bufio.Writer) (int, error) {
+	return w.Write(v.Bytes())
+}
+
+// Value of the type
+func (v *Verbatim) Value() interface{} {
+	return v.Data
+}
+
+// BlobError is a RESP3 binary safe error type
+type BlobError struct {
+	Data []byte
+}
+
+// Bytes returns the bytes representation
+func (e *BlobError) Bytes() []byte {
+	return []byte(fmt.Sprintf("!%d\r\n%s\r\n", len(e.Data), e.Data))
+}
+
+// Stream the bytes to the writer
+func (e *BlobError) Stream(w *bufio.Writer) (int, error) {
+	return w.Write(e.Bytes())
+}
+
+// Error returns the error message
+func (e *BlobError) Error() string {
+	return string(e.Data)
+}
+
+// Value of the type
+func (e *BlobError) Value() interface{} {
+	return error(e)
+}
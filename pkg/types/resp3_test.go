@@ -0,0 +1,118 @@
+package types
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRESP3_Bytes(t *testing.T) {
+	d := Double(1.5)
+	inf := Double(math.Inf(-1))
+	yes := Boolean(true)
+	no := Boolean(false)
+	i := Integer(1)
+	n, _ := new(big.Int).SetString("3492890328409238509324850943850943825024385", 10)
+	tests := []struct {
+		name string
+		t    Type
+		want []byte
+	}{
+		{
+			name: "map",
+			t: &Map{Pairs: []MapPair{
+				{Key: &BulkString{Data: []byte("a")}, Value: &i},
+			}},
+			want: []byte("%1\r\n$1\r\na\r\n:1\r\n"),
+		},
+		{
+			name: "set",
+			t:    &Set{Contents: []Type{&i, &yes}},
+			want: []byte("~2\r\n:1\r\n#t\r\n"),
+		},
+		{
+			name: "push",
+			t:    &Push{Contents: []Type{&BulkString{Data: []byte("message")}}},
+			want: []byte(">1\r\n$7\r\nmessage\r\n"),
+		},
+		{
+			name: "attribute",
+			t: &Attribute{
+				Pairs: []MapPair{{Key: &BulkString{Data: []byte("ttl")}, Value: &i}},
+				Reply: &i,
+			},
+			want: []byte("|1\r\n$3\r\nttl\r\n:1\r\n:1\r\n"),
+		},
+		{
+			name: "double",
+			t:    &d,
+			want: []byte(",1.5\r\n"),
+		},
+		{
+			name: "negative infinity",
+			t:    &inf,
+			want: []byte(",-inf\r\n"),
+		},
+		{
+			name: "false",
+			t:    &no,
+			want: []byte("#f\r\n"),
+		},
+		{
+			name: "null",
+			t:    &Null{},
+			want: []byte("_\r\n"),
+		},
+		{
+			name: "big number",
+			t:    &BigNumber{Int: n},
+			want: []byte("(3492890328409238509324850943850943825024385\r\n"),
+		},
+		{
+			name: "verbatim",
+			t:    &Verbatim{Format: "txt", Data: []byte("Some string")},
+			want: []byte("=15\r\ntxt:Some string\r\n"),
+		},
+		{
+			name: "blob error",
+			t:    &BlobError{Data: []byte("SYNTAX invalid syntax")},
+			want: []byte("!21\r\nSYNTAX invalid syntax\r\n"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.t.Bytes())
+			buf := bytes.NewBuffer([]byte{})
+			w := bufio.NewWriter(buf)
+			nn, err := tt.t.Stream(w)
+			assert.NoError(t, err)
+			assert.NoError(t, w.Flush())
+			assert.Equal(t, len(tt.want), nn)
+			assert.Equal(t, tt.want, buf.Bytes())
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	d := Double(2.25)
+	yes := Boolean(true)
+	i := Integer(7)
+	m := &Map{Pairs: []MapPair{
+		{Key: &BulkString{Data: []byte("d")}, Value: &d},
+		{Key: &BulkString{Data: []byte("n")}, Value: &Null{}},
+		{Key: &BulkString{Data: []byte("s")}, Value: &Set{Contents: []Type{&yes}}},
+		{Key: &BulkString{Data: []byte("e")}, Value: &BlobError{Data: []byte("a\r\nb")}},
+		{Key: &BulkString{Data: []byte("a")}, Value: &Attribute{Reply: &i}},
+	}}
+	assert.Equal(t,
+		[]byte("*10\r\n$1\r\nd\r\n$4\r\n2.25\r\n$1\r\nn\r\n$-1\r\n$1\r\ns\r\n*1\r\n:1\r\n$1\r\ne\r\n-a  b\r\n$1\r\na\r\n:7\r\n"),
+		Render(m, RESP2).Bytes())
+	assert.Equal(t, m.Bytes(), Render(m, RESP3).Bytes())
+	assert.Equal(t,
+		[]byte("*2\r\n_\r\n$0\r\n\r\n"),
+		Render(&Array{Contents: []Type{&BulkString{}, &BulkString{Data: []byte{}}}}, RESP3).Bytes())
+}
@@ -3,10 +3,11 @@ package types
 import (
 	"bufio"
 	"bytes"
-	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"strconv"
 	"strings"
 )
@@ -14,8 +15,34 @@ import (
 var (
 	// ErrClosedConnection is thrown when the connection is closed
 	ErrClosedConnection = errors.New("closed connection")
+	// ErrBulkLength is thrown when a bulk string is longer than allowed
+	ErrBulkLength = ProtocolError("invalid bulk length")
+	// ErrMultiBulkLength is thrown when an aggregate has more elements than allowed
+	ErrMultiBulkLength = ProtocolError("invalid multibulk length")
+	// ErrInlineLength is thrown when a line is longer than allowed
+	ErrInlineLength = ProtocolError("too big inline request")
+	// ErrNesting is thrown when aggregates are nested deeper than allowed
+	ErrNesting = ProtocolError("too many nested aggregates")
 )
 
+// ProtocolError is a violation of the scanner's limits, the stream can't be recovered after one
+type ProtocolError string
+
+func (e ProtocolError) Error() string {
+	return fmt.Sprintf("Protocol error: %s", string(e))
+}
+
+// Limits bound what a Scanner will accept, a zero field is unlimited
+type Limits struct {
+	MaxBulkLen      int64
+	MaxMultiBulkLen int64
+	MaxInlineLen    int
+	MaxNesting      int
+}
+
+// bulkChunk is the most a bulk string allocates before its data arrives
+const bulkChunk = 64 * 1024
+
 // Scanner provides an interface for scanning in RESP from IO
 type Scanner struct {
 	r          io.Reader
@@ -24,27 +51,45 @@ type Scanner struct {
 	scanCalled bool
 	done       bool
 	t          Type
+	protocol   int
+	limits     Limits
 }
 
-// NewScanner returns a new scanner
+// NewScanner returns a new scanner that understands every RESP3 type
 func NewScanner(r io.Reader) *Scanner {
 	return &Scanner{
-		r:    r,
-		read: bufio.NewReader(r),
+		r:        r,
+		read:     bufio.NewReader(r),
+		protocol: RESP3,
 	}
 }
 
+// SetProtocol sets the protocol version to scan, lines starting with a
+// RESP3 only prefix are read as inline commands under RESP2
+func (s *Scanner) SetProtocol(protocol int) {
+	s.protocol = protocol
+}
+
+// SetLimits bounds the sizes the scanner accepts
+func (s *Scanner) SetLimits(limits Limits) {
+	s.limits = limits
+}
+
 // Scan the next type in until connection is closed
 func (s *Scanner) Scan() bool {
 	if s.done {
 		return false
 	}
 	s.err = nil
-	s.t, s.err = s.scanType()
+	s.t, s.err = s.scanType(1)
 	if s.err == io.EOF || s.err == io.ErrClosedPipe || s.err == io.ErrUnexpectedEOF {
 		s.done = true
 		return false
 	}
+	if _, ok := s.err.(ProtocolError); ok {
+		// Report the error, then stop scanning
+		s.done = true
+	}
 	return true
 }
 
@@ -61,22 +106,74 @@ func (s *Scanner) Type() Type {
 	return s.t
 }
 
-func (s *Scanner) scanType() (Type, error) {
-	val, err := s.read.ReadSlice('\n')
-	if err != nil {
-		return nil, err
+// readLine reads up to and including the next LF, enforcing the inline limit
+func (s *Scanner) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		val, err := s.read.ReadSlice('\n')
+		if err != bufio.ErrBufferFull {
+			if line != nil {
+				val = append(line, val...)
+			}
+			if err == nil && s.limits.MaxInlineLen > 0 && len(val) > s.limits.MaxInlineLen+2 {
+				return nil, ErrInlineLength
+			}
+			return val, err
+		}
+		line = append(line, val...)
+		if s.limits.MaxInlineLen > 0 && len(line) > s.limits.MaxInlineLen+2 {
+			return nil, ErrInlineLength
+		}
 	}
-	if len(val) == 0 {
-		return nil, ErrClosedConnection
+}
+
+// typePrefix reports whether a line starting with c is a RESP type rather than an inline command
+func (s *Scanner) typePrefix(c byte) bool {
+	if strings.IndexByte("+-:$*", c) >= 0 {
+		return true
 	}
-	// Trim off the CR
-	if val[len(val)-2] != '\r' {
-		s.read.Reset(s.r)
-		return nil, fmt.Errorf("Invalid CRLF, expected \"\\r\\n\"")
+	return s.protocol >= RESP3 && strings.IndexByte("%~>|,#_(=!", c) >= 0
+}
+
+func (s *Scanner) scanType(depth int) (Type, error) {
+	val, err := s.readLine()
+	for err == nil {
+		if len(val) == 0 {
+			return nil, ErrClosedConnection
+		}
+		// Trim off the LF and CR, inline commands may end with a bare LF like in telnet
+		val = val[:len(val)-1]
+		cr := len(val) > 0 && val[len(val)-1] == '\r'
+		if cr {
+			val = val[:len(val)-1]
+		}
+		if len(val) > 0 && s.typePrefix(val[0]) {
+			if !cr {
+				s.read.Reset(s.r)
+				return nil, fmt.Errorf("Invalid CRLF, expected \"\\r\\n\"")
+			}
+			break
+		}
+		args, err := SplitArgs(val)
+		if err != nil {
+			return nil, err
+		}
+		// Empty inline commands are ignored
+		if len(args) > 0 {
+			t := []Type{}
+			for _, a := range args {
+				t = append(t, &BulkString{Data: a})
+			}
+			return &Array{Contents: t}, nil
+		}
+		val, err = s.readLine()
+	}
+	if err != nil {
+		return nil, err
 	}
-	val = val[:len(val)-2]
 
-	switch val[0] {
+	prefix := val[0]
+	switch prefix {
 	case '+':
 		t := SimpleString(string(val[1:]))
 		return &t, nil
@@ -92,15 +189,122 @@ func (s *Scanner) scanType() (Type, error) {
 		t := Integer(i)
 		return &t, nil
 	case '$':
-		i, err := strconv.ParseInt(string(val[1:]), 10, 64)
+		buf, err := s.scanBlob(val[1:])
 		if err != nil {
+			return nil, err
+		}
+		return &BulkString{Data: buf}, nil
+	case '*':
+		t, err := s.scanAggregate(val[1:], depth)
+		if err != nil {
+			return nil, err
+		}
+		return &Array{Contents: t}, nil
+	case '~':
+		t, err := s.scanAggregate(val[1:], depth)
+		if err != nil {
+			return nil, err
+		}
+		return &Set{Contents: t}, nil
+	case '>':
+		t, err := s.scanAggregate(val[1:], depth)
+		if err != nil {
+			return nil, err
+		}
+		return &Push{Contents: t}, nil
+	case '%':
+		pairs, err := s.scanPairs(val[1:], depth)
+		if err != nil {
+			return nil, err
+		}
+		return &Map{Pairs: pairs}, nil
+	case '|':
+		pairs, err := s.scanPairs(val[1:], depth)
+		if err != nil {
+			return nil, err
+		}
+		reply, err := s.scanType(depth)
+		if err != nil {
+			return nil, err
+		}
+		return &Attribute{Pairs: pairs, Reply: reply}, nil
+	case ',':
+		var f float64
+		switch string(val[1:]) {
+		case "inf", "+inf":
+			f = math.Inf(1)
+		case "-inf":
+			f = math.Inf(-1)
+		case "nan", "-nan":
+			f = math.NaN()
+		default:
+			f, err = strconv.ParseFloat(string(val[1:]), 64)
+			if err != nil {
+				s.read.Reset(s.r)
+				return nil, err
+			}
+		}
+		t := Double(f)
+		return &t, nil
+	case '#':
+		switch string(val[1:]) {
+		case "t":
+			t := Boolean(true)
+			return &t, nil
+		case "f":
+			t := Boolean(false)
+			return &t, nil
+		}
+		s.read.Reset(s.r)
+		return nil, fmt.Errorf("Invalid boolean, expected t or f")
+	case '_':
+		if len(val) != 1 {
+			s.read.Reset(s.r)
+			return nil, fmt.Errorf("Invalid null")
+		}
+		return &Null{}, nil
+	case '(':
+		i, ok := new(big.Int).SetString(string(val[1:]), 10)
+		if !ok {
 			s.read.Reset(s.r)
+			return nil, fmt.Errorf("Invalid big number")
+		}
+		return &BigNumber{Int: i}, nil
+	case '=':
+		buf, err := s.scanBlob(val[1:])
+		if err != nil {
 			return nil, err
 		}
-		if i < 0 {
-			return &BulkString{Data: nil}, nil
+		if len(buf) < 4 || buf[3] != ':' {
+			return nil, fmt.Errorf("Verbatim string must start with a format")
 		}
-		buf := make([]byte, i, i)
+		return &Verbatim{Format: string(buf[:3]), Data: buf[4:]}, nil
+	case '!':
+		buf, err := s.scanBlob(val[1:])
+		if err != nil {
+			return nil, err
+		}
+		return &BlobError{Data: buf}, nil
+	}
+	return nil, fmt.Errorf("Unknown type '%c'", val[0])
+}
+
+// scanBlob reads a length prefixed payload, a negative length returns nil
+func (s *Scanner) scanBlob(length []byte) ([]byte, error) {
+	i, err := strconv.ParseInt(string(length), 10, 64)
+	if err != nil {
+		s.read.Reset(s.r)
+		return nil, err
+	}
+	if i < 0 {
+		return nil, nil
+	}
+	if s.limits.MaxBulkLen > 0 && i > s.limits.MaxBulkLen {
+		return nil, ErrBulkLength
+	}
+	var buf []byte
+	if i <= bulkChunk {
+		buf = make([]byte, i, i)
 		if i > 0 {
 			n, err := io.ReadFull(s.read, buf)
 			if err != nil {
@@ -111,49 +315,103 @@ func (s *Scanner) scanType() (Type, error) {
 				return nil, fmt.Errorf("Expected %d bytes but got %d bytes", i, n)
 			}
 		}
-		crlf := make([]byte, 2, 2)
-		n, err := io.ReadFull(s.read, crlf)
+	} else {
+		// Grow with the data actually received rather than trusting the length
+		b := bytes.NewBuffer(make([]byte, 0, bulkChunk))
+		n, err := io.CopyN(b, s.read, i)
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
 		if err != nil {
 			return nil, err
 		}
-		if n != 2 {
-			s.read.Reset(s.r)
-			return nil, fmt.Errorf("Unexpected early end of string")
-		}
-		if bytes.Compare(crlf, []byte{'\r', '\n'}) != 0 {
+		if n < i {
 			s.read.Reset(s.r)
-			return nil, fmt.Errorf("Terminating CRLF not found")
+			return nil, fmt.Errorf("Expected %d bytes but got %d bytes", i, n)
 		}
-		return &BulkString{Data: buf}, nil
-	case '*':
-		n, err := strconv.ParseInt(string(val[1:]), 10, 64)
+		buf = b.Bytes()
+	}
+	crlf := make([]byte, 2, 2)
+	n, err := io.ReadFull(s.read, crlf)
+	if err != nil {
+		return nil, err
+	}
+	if n != 2 {
+		s.read.Reset(s.r)
+		return nil, fmt.Errorf("Unexpected early end of string")
+	}
+	if bytes.Compare(crlf, []byte{'\r', '\n'}) != 0 {
+		s.read.Reset(s.r)
+		return nil, fmt.Errorf("Terminating CRLF not found")
+	}
+	return buf, nil
+}
+
+// scanCount reads the number of elements in an aggregate
+func (s *Scanner) scanCount(count []byte) (int64, error) {
+	n, err := strconv.ParseInt(string(count), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		s.read.Reset(s.r)
+		return 0, fmt.Errorf("Number of indexes must be zero or positive")
+	}
+	if s.limits.MaxMultiBulkLen > 0 && n > s.limits.MaxMultiBulkLen {
+		return 0, ErrMultiBulkLength
+	}
+	return n, nil
+}
+
+// checkNesting fails once an aggregate at depth would exceed the nesting limit
+func (s *Scanner) checkNesting(depth int) error {
+	if s.limits.MaxNesting > 0 && depth > s.limits.MaxNesting {
+		return ErrNesting
+	}
+	return nil
+}
+
+func (s *Scanner) scanAggregate(count []byte, depth int) ([]Type, error) {
+	if err := s.checkNesting(depth); err != nil {
+		return nil, err
+	}
+	n, err := s.scanCount(count)
+	if err != nil {
+		return nil, err
+	}
+	t := []Type{}
+	for i := int64(0); i < n; i++ {
+		v, err := s.scanType(depth + 1)
 		if err != nil {
 			return nil, err
 		}
-		if n < 0 {
-			s.read.Reset(s.r)
-			return nil, fmt.Errorf("Number of indexes must be zero or positive")
-		}
-		t := []Type{}
-		for i := int64(0); i < n; i++ {
-			v, err := s.scanType()
-			if err != nil {
-				return nil, err
-			}
-			t = append(t, v)
-		}
-		return &Array{Contents: t}, nil
-	default:
-		r := csv.NewReader(strings.NewReader(string(val)))
-		r.Comma = ' '
-		record, err := r.Read()
+		t = append(t, v)
+	}
+	return t, nil
+}
+
+func (s *Scanner) scanPairs(count []byte, depth int) ([]MapPair, error) {
+	if err := s.checkNesting(depth); err != nil {
+		return nil, err
+	}
+	n, err := s.scanCount(count)
+	if err != nil {
+		return nil, err
+	}
+	if s.limits.MaxMultiBulkLen > 0 && n > s.limits.MaxMultiBulkLen/2 {
+		return nil, ErrMultiBulkLength
+	}
+	pairs := []MapPair{}
+	for i := int64(0); i < n; i++ {
+		k, err := s.scanType(depth + 1)
 		if err != nil {
 			return nil, err
 		}
-		t := []Type{}
-		for _, s := range record {
-			t = append(t, &BulkString{Data: []byte(s)})
+		v, err := s.scanType(depth + 1)
+		if err != nil {
+			return nil, err
 		}
-		return &Array{Contents: t}, nil
+		pairs = append(pairs, MapPair{Key: k, Value: v})
 	}
+	return pairs, nil
 }
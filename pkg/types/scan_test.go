@@ -2,7 +2,10 @@ package types
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"reflect"
 	"testing"
 
@@ -13,6 +16,13 @@ func TestScanner_Type(t *testing.T) {
 	ss := SimpleString("OK")
 	e := Error("BAD")
 	i := Integer(1234)
+	i1 := Integer(1)
+	key := SimpleString("key")
+	yes := Boolean(true)
+	no := Boolean(false)
+	d := Double(-1500)
+	inf := Double(math.Inf(1))
+	bn, _ := new(big.Int).SetString("-3492890328409238509324850943850943825024385", 10)
 	tests := []struct {
 		name string
 		r    io.Reader
@@ -85,6 +95,56 @@ func TestScanner_Type(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "map",
+			r:    bytes.NewBufferString("%1\r\n+key\r\n:1\r\n"),
+			want: &Map{Pairs: []MapPair{{Key: &key, Value: &i1}}},
+		},
+		{
+			name: "set",
+			r:    bytes.NewBufferString("~2\r\n#t\r\n#f\r\n"),
+			want: &Set{Contents: []Type{&yes, &no}},
+		},
+		{
+			name: "push",
+			r:    bytes.NewBufferString(">1\r\n:1\r\n"),
+			want: &Push{Contents: []Type{&i1}},
+		},
+		{
+			name: "attribute",
+			r:    bytes.NewBufferString("|1\r\n+key\r\n:1\r\n:1234\r\n"),
+			want: &Attribute{Pairs: []MapPair{{Key: &key, Value: &i1}}, Reply: &i},
+		},
+		{
+			name: "double",
+			r:    bytes.NewBufferString(",-1.5e3\r\n"),
+			want: &d,
+		},
+		{
+			name: "infinite double",
+			r:    bytes.NewBufferString(",inf\r\n"),
+			want: &inf,
+		},
+		{
+			name: "null",
+			r:    bytes.NewBufferString("_\r\n"),
+			want: &Null{},
+		},
+		{
+			name: "big number",
+			r:    bytes.NewBufferString("(-3492890328409238509324850943850943825024385\r\n"),
+			want: &BigNumber{Int: bn},
+		},
+		{
+			name: "verbatim string",
+			r:    bytes.NewBufferString("=15\r\ntxt:Some string\r\n"),
+			want: &Verbatim{Format: "txt", Data: []byte("Some string")},
+		},
+		{
+			name: "blob error",
+			r:    bytes.NewBufferString("!21\r\nSYNTAX invalid syntax\r\n"),
+			want: &BlobError{Data: []byte("SYNTAX invalid syntax")},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -139,6 +199,21 @@ func TestScanner_Err(t *testing.T) {
 			r:       bytes.NewBufferString("*-1\r\n\r\n"),
 			message: "Number of indexes must be zero or positive",
 		},
+		{
+			name:    "invalid boolean",
+			r:       bytes.NewBufferString("#x\r\n"),
+			message: "Invalid boolean, expected t or f",
+		},
+		{
+			name:    "invalid big number",
+			r:       bytes.NewBufferString("(12a\r\n"),
+			message: "Invalid big number",
+		},
+		{
+			name:    "verbatim without format",
+			r:       bytes.NewBufferString("=2\r\nab\r\n"),
+			message: "Verbatim string must start with a format",
+		},
 		{
 			name:    "invalid index length",
 			r:       bytes.NewBufferString("*abc\r\n"),
@@ -193,3 +268,115 @@ func TestScanner_ErrReset(t *testing.T) {
 		})
 	}
 }
+
+func TestScanner_SetProtocol(t *testing.T) {
+	s := NewScanner(bytes.NewBufferString("!TEST\r\n#t\r\n"))
+	s.SetProtocol(RESP2)
+	assert.True(t, s.Scan())
+	assert.NoError(t, s.Err())
+	assert.Equal(t, &Array{Contents: []Type{&BulkString{Data: []byte("!TEST")}}}, s.Type())
+	s.SetProtocol(RESP3)
+	assert.True(t, s.Scan())
+	assert.NoError(t, s.Err())
+	b := Boolean(true)
+	assert.Equal(t, &b, s.Type())
+}
+
+func TestScanner_Limits(t *testing.T) {
+	limits := Limits{
+		MaxBulkLen:      8,
+		MaxMultiBulkLen: 4,
+		MaxInlineLen:    20,
+		MaxNesting:      2,
+	}
+	tests := []struct {
+		name string
+		r    io.Reader
+		err  error
+	}{
+		{
+			name: "bulk length",
+			r:    bytes.NewBufferString("$9\r\n"),
+			err:  ErrBulkLength,
+		},
+		{
+			name: "huge bulk length",
+			r:    bytes.NewBufferString("*1\r\n$9223372036854775807\r\n"),
+			err:  ErrBulkLength,
+		},
+		{
+			name: "multibulk length",
+			r:    bytes.NewBufferString("*5\r\n"),
+			err:  ErrMultiBulkLength,
+		},
+		{
+			name: "map length",
+			r:    bytes.NewBufferString("%3\r\n"),
+			err:  ErrMultiBulkLength,
+		},
+		{
+			name: "inline length",
+			r:    bytes.NewBufferString("SET key aaaaaaaaaaaaaaa\r\n"),
+			err:  ErrInlineLength,
+		},
+		{
+			name: "unterminated inline length",
+			r:    bytes.NewBufferString(string(bytes.Repeat([]byte("a"), 8192))),
+			err:  ErrInlineLength,
+		},
+		{
+			name: "nesting",
+			r:    bytes.NewBufferString("*1\r\n*1\r\n*1\r\n:1\r\n"),
+			err:  ErrNesting,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScanner(tt.r)
+			s.SetLimits(limits)
+			assert.True(t, s.Scan())
+			assert.Equal(t, tt.err, s.Err())
+			assert.Equal(t, "Protocol error: "+string(tt.err.(ProtocolError)), s.Err().Error())
+			assert.False(t, s.Scan())
+		})
+	}
+
+	t.Run("within limits", func(t *testing.T) {
+		s := NewScanner(bytes.NewBufferString("*2\r\n*1\r\n$8\r\n12345678\r\n:1\r\nSET key aaaaaaa\r\n"))
+		s.SetLimits(limits)
+		assert.True(t, s.Scan())
+		assert.NoError(t, s.Err())
+		assert.True(t, s.Scan())
+		assert.NoError(t, s.Err())
+	})
+}
+
+func TestScanner_LargeBulk(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 3*bulkChunk+7)
+	r := bytes.NewBufferString(fmt.Sprintf("$%d\r\n", len(data)))
+	r.Write(data)
+	r.WriteString(fmt.Sprintf("\r\n$%d\r\nshort", len(data)))
+	s := NewScanner(r)
+	assert.True(t, s.Scan())
+	assert.NoError(t, s.Err())
+	assert.Equal(t, &BulkString{Data: data}, s.Type())
+	assert.False(t, s.Scan())
+	assert.Equal(t, io.ErrUnexpectedEOF, s.Err())
+}
+
+func TestScanner_Inline(t *testing.T) {
+	s := NewScanner(bytes.NewBufferString("\n\r\n   \r\nset 'a b'  \"\\x41\"\nping\r\nget \"unbalanced\r\nping\r\n"))
+	assert.True(t, s.Scan())
+	assert.NoError(t, s.Err())
+	assert.Equal(t, &Array{Contents: []Type{
+		&BulkString{Data: []byte("set")},
+		&BulkString{Data: []byte("a b")},
+		&BulkString{Data: []byte("A")},
+	}}, s.Type())
+	assert.True(t, s.Scan())
+	assert.NoError(t, s.Err())
+	assert.Equal(t, &Array{Contents: []Type{&BulkString{Data: []byte("ping")}}}, s.Type())
+	assert.True(t, s.Scan())
+	assert.Equal(t, ErrUnbalancedQuotes, s.Err())
+	assert.False(t, s.Scan())
+}
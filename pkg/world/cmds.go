@@ -0,0 +1,342 @@
+package world
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/furui/gochunk/pkg/config"
+	"github.com/furui/gochunk/pkg/db"
+	"github.com/furui/gochunk/pkg/processor"
+	"github.com/furui/gochunk/pkg/state"
+	respTypes "github.com/furui/gochunk/pkg/types"
+)
+
+func addSetTileCmd(config *config.Config, processor processor.Processor) {
+	processor.AddCommand("WSETTILE", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		if len(params) != 4 {
+			return nil, fmt.Errorf("four parameters expected")
+		}
+		x, err := parseCoordinate(params[1])
+		if err != nil {
+			return nil, err
+		}
+		y, err := parseCoordinate(params[2])
+		if err != nil {
+			return nil, err
+		}
+		v, err := parseTile(params[3])
+		if err != nil {
+			return nil, err
+		}
+		d, err := dbManager.Get(state.Database())
+		if err != nil {
+			return nil, err
+		}
+		if err := d.SetTile(params[0], x, y, v); err != nil {
+			return nil, err
+		}
+		t := respTypes.SimpleString("OK")
+		return &t, nil
+	})
+}
+
+func addGetTileCmd(config *config.Config, processor processor.Processor) {
+	processor.AddCommand("WGETTILE", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		if len(params) != 3 {
+			return nil, fmt.Errorf("three parameters expected")
+		}
+		x, err := parseCoordinate(params[1])
+		if err != nil {
+			return nil, err
+		}
+		y, err := parseCoordinate(params[2])
+		if err != nil {
+			return nil, err
+		}
+		d, err := dbManager.Get(state.Database())
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.Tile(params[0], x, y)
+		if err != nil {
+			return nil, err
+		}
+		t := respTypes.Integer(v)
+		return &t, nil
+	})
+}
+
+func addFillCmd(config *config.Config, processor processor.Processor) {
+	processor.AddCommand("WFILL", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		if len(params) != 6 {
+			return nil, fmt.Errorf("six parameters expected")
+		}
+		coords := make([]int64, 4)
+		for i := range coords {
+			c, err := parseCoordinate(params[i+1])
+			if err != nil {
+				return nil, err
+			}
+			coords[i] = c
+		}
+		v, err := parseTile(params[5])
+		if err != nil {
+			return nil, err
+		}
+		d, err := dbManager.Get(state.Database())
+		if err != nil {
+			return nil, err
+		}
+		n, err := d.FillTiles(params[0], coords[0], coords[1], coords[2], coords[3], v)
+		if err != nil {
+			return nil, err
+		}
+		t := respTypes.Integer(n)
+		return &t, nil
+	})
+}
+
+func addChunkCmd(config *config.Config, processor processor.Processor) {
+	processor.AddCommand("WCHUNK", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		if len(params) != 3 {
+			return nil, fmt.Errorf("three parameters expected")
+		}
+		cx, err := parseCoordinate(params[1])
+		if err != nil {
+			return nil, err
+		}
+		cy, err := parseCoordinate(params[2])
+		if err != nil {
+			return nil, err
+		}
+		d, err := dbManager.Get(state.Database())
+		if err != nil {
+			return nil, err
+		}
+		data, err := d.TileChunk(params[0], cx, cy)
+		if err != nil {
+			return nil, err
+		}
+		return &respTypes.BulkString{Data: data}, nil
+	})
+}
+
+func addChunksCmd(config *config.Config, processor processor.Processor) {
+	processor.AddCommand("WCHUNKS", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		if len(params) != 1 {
+			return nil, fmt.Errorf("one parameter expected")
+		}
+		d, err := dbManager.Get(state.Database())
+		if err != nil {
+			return nil, err
+		}
+		contents := []respTypes.Type{}
+		for _, c := range d.ResidentChunks(params[0]) {
+			cx, cy := respTypes.Integer(c[0]), respTypes.Integer(c[1])
+			contents = append(contents, &respTypes.Array{Contents: []respTypes.Type{&cx, &cy}})
+		}
+		return &respTypes.Array{Contents: contents}, nil
+	})
+}
+
+func addSnapshotCmd(config *config.Config, processor processor.Processor) {
+	processor.AddCommand("WSNAPSHOT", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		del := false
+		switch {
+		case len(params) == 3 && strings.ToUpper(string(params[2])) == "DEL":
+			del = true
+		case len(params) != 2:
+			return nil, fmt.Errorf("expected id name [DEL]")
+		}
+		d, err := dbManager.Get(state.Database())
+		if err != nil {
+			return nil, err
+		}
+		if del {
+			err = d.DeleteSnapshot(params[0], params[1])
+		} else {
+			err = d.Snapshot(params[0], params[1])
+		}
+		if err != nil {
+			return nil, err
+		}
+		t := respTypes.SimpleString("OK")
+		return &t, nil
+	})
+}
+
+func addSnapshotsCmd(config *config.Config, processor processor.Processor) {
+	processor.AddCommand("WSNAPSHOTS", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		if len(params) != 1 {
+			return nil, fmt.Errorf("one parameter expected")
+		}
+		d, err := dbManager.Get(state.Database())
+		if err != nil {
+			return nil, err
+		}
+		names, err := d.Snapshots(params[0])
+		if err != nil {
+			return nil, err
+		}
+		contents := []respTypes.Type{}
+		for _, n := range names {
+			contents = append(contents, &respTypes.BulkString{Data: n})
+		}
+		return &respTypes.Array{Contents: contents}, nil
+	})
+}
+
+func addRollbackCmd(config *config.Config, processor processor.Processor) {
+	processor.AddCommand("WROLLBACK", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		if len(params) != 2 {
+			return nil, fmt.Errorf("two parameters expected")
+		}
+		d, err := dbManager.Get(state.Database())
+		if err != nil {
+			return nil, err
+		}
+		if err := d.Rollback(params[0], params[1]); err != nil {
+			return nil, err
+		}
+		t := respTypes.SimpleString("OK")
+		return &t, nil
+	})
+}
+
+func addExportCmd(config *config.Config, processor processor.Processor) {
+	processor.AddCommand("WEXPORT", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		format := FormatJSON
+		switch {
+		case len(params) == 3 && strings.ToUpper(string(params[1])) == "FORMAT":
+			f, err := ParseFormat(string(params[2]))
+			if err != nil {
+				return nil, err
+			}
+			format = f
+		case len(params) != 1:
+			return nil, fmt.Errorf("expected id [FORMAT json|geojson]")
+		}
+		d, err := dbManager.Get(state.Database())
+		if err != nil {
+			return nil, err
+		}
+		data, err := Export(d, params[0], format)
+		if err != nil {
+			return nil, err
+		}
+		return &respTypes.BulkString{Data: data}, nil
+	})
+}
+
+func addImportCmd(config *config.Config, processor processor.Processor) {
+	processor.AddCommand("WIMPORT", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		format := FormatJSON
+		switch {
+		case len(params) == 4 && strings.ToUpper(string(params[1])) == "FORMAT":
+			f, err := ParseFormat(string(params[2]))
+			if err != nil {
+				return nil, err
+			}
+			format = f
+		case len(params) != 2:
+			return nil, fmt.Errorf("expected id [FORMAT json|geojson] data")
+		}
+		d, err := dbManager.Get(state.Database())
+		if err != nil {
+			return nil, err
+		}
+		if err := Import(d, params[0], format, params[len(params)-1]); err != nil {
+			return nil, err
+		}
+		t := respTypes.SimpleString("OK")
+		return &t, nil
+	})
+}
+
+func addInfoCmd(config *config.Config, processor processor.Processor) {
+	processor.AddCommand("WINFO", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		if len(params) != 1 {
+			return nil, fmt.Errorf("one parameter expected")
+		}
+		d, err := dbManager.Get(state.Database())
+		if err != nil {
+			return nil, err
+		}
+		stats, err := d.TileStats(params[0])
+		if err != nil {
+			return nil, err
+		}
+		fields := []struct {
+			name  string
+			value int64
+		}{
+			{"chunks", stats.Chunks},
+			{"resident_chunks", stats.ResidentChunks},
+			{"memory_bytes", stats.ResidentBytes},
+		}
+		contents := []respTypes.Type{}
+		for _, f := range fields {
+			v := respTypes.Integer(f.value)
+			contents = append(contents, &respTypes.BulkString{Data: []byte(f.name)}, &v)
+		}
+		return &respTypes.Array{Contents: contents}, nil
+	})
+}
+
+func addPathCmd(config *config.Config, processor processor.Processor) {
+	processor.AddCommand("WPATH", func(dbManager db.Manager, state state.Client, params [][]byte) (respTypes.Type, error) {
+		if len(params) < 5 {
+			return nil, fmt.Errorf("at least five parameters expected")
+		}
+		coords := make([]int64, 4)
+		for i := range coords {
+			c, err := parseCoordinate(params[i+1])
+			if err != nil {
+				return nil, err
+			}
+			coords[i] = c
+		}
+		diagonal := false
+		maxNodes := DefaultMaxNodes
+		if maxNodes > config.PathMaxNodes {
+			maxNodes = config.PathMaxNodes
+		}
+		for i := 5; i < len(params); i++ {
+			switch strings.ToUpper(string(params[i])) {
+			case "DIAGONAL":
+				diagonal = true
+			case "MAXNODES":
+				if i+1 >= len(params) {
+					return nil, fmt.Errorf("MAXNODES requires a value")
+				}
+				i++
+				n, err := strconv.Atoi(string(params[i]))
+				if err != nil || n < 1 {
+					return nil, fmt.Errorf("invalid MAXNODES")
+				}
+				if n > config.PathMaxNodes {
+					return nil, fmt.Errorf("MAXNODES can't be more than %d", config.PathMaxNodes)
+				}
+				maxNodes = n
+			default:
+				return nil, fmt.Errorf("unknown option '%s'", params[i])
+			}
+		}
+		d, err := dbManager.Get(state.Database())
+		if err != nil {
+			return nil, err
+		}
+		tiles := &tileCache{d: d, world: params[0], chunks: make(map[point][]byte)}
+		path, err := findPath(tiles, point{coords[0], coords[1]}, point{coords[2], coords[3]}, diagonal, maxNodes)
+		if err != nil {
+			return nil, err
+		}
+		contents := []respTypes.Type{}
+		for _, p := range path {
+			x, y := respTypes.Integer(p.x), respTypes.Integer(p.y)
+			contents = append(contents, &respTypes.Array{Contents: []respTypes.Type{&x, &y}})
+		}
+		return &respTypes.Array{Contents: contents}, nil
+	})
+}
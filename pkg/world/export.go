@@ -0,0 +1,309 @@
+package world
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/furui/gochunk/pkg/db"
+)
+
+const (
+	// MaxExportSize is the largest export WEXPORT replies with, the export subcommand has no limit
+	MaxExportSize = 64 << 20
+
+	// FormatJSON exports a world as chunks of base64 encoded tiles
+	FormatJSON = "json"
+	// FormatGeoJSON exports a world as a feature collection with a square polygon per non-empty tile
+	FormatGeoJSON = "geojson"
+)
+
+var (
+	// ErrUnknownFormat is thrown when exporting or importing an unsupported format
+	ErrUnknownFormat = errors.New("unknown format, expected json or geojson")
+	// ErrInvalidImport is thrown when imported data can't be converted into tiles
+	ErrInvalidImport = errors.New("invalid import data")
+	// ErrExportTooLarge is thrown when an export grows past MaxExportSize
+	ErrExportTooLarge = errors.New("export too large, use the export subcommand")
+)
+
+type jsonChunk struct {
+	CX    int64  `json:"cx"`
+	CY    int64  `json:"cy"`
+	Tiles []byte `json:"tiles"`
+}
+
+type jsonWorld struct {
+	World     string      `json:"world"`
+	ChunkSize int         `json:"chunkSize"`
+	Chunks    []jsonChunk `json:"chunks"`
+}
+
+type geoJSONGeometry struct {
+	Type        string         `json:"type"`
+	Coordinates [][][2]float64 `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// ParseFormat validates an export or import format name
+func ParseFormat(format string) (string, error) {
+	f := strings.ToLower(format)
+	if f != FormatJSON && f != FormatGeoJSON {
+		return "", ErrUnknownFormat
+	}
+	return f, nil
+}
+
+// limitWriter buffers an export, failing once it grows past its limit
+type limitWriter struct {
+	bytes.Buffer
+	limit int
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.Len()+len(p) > l.limit {
+		return 0, ErrExportTooLarge
+	}
+	return l.Buffer.Write(p)
+}
+
+// Export encodes all of a world's tiles in the given format, up to MaxExportSize bytes
+func Export(d db.Database, world []byte, format string) ([]byte, error) {
+	w := &limitWriter{limit: MaxExportSize}
+	if err := WriteExport(w, d, world, format); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+// WriteExport streams all of a world's tiles in the given format
+func WriteExport(w io.Writer, d db.Database, world []byte, format string) error {
+	var header []byte
+	var err error
+	switch format {
+	case FormatJSON:
+		header, err = json.Marshal(jsonWorld{World: string(world), ChunkSize: db.ChunkSize, Chunks: []jsonChunk{}})
+	case FormatGeoJSON:
+		header, err = json.Marshal(geoJSONCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}})
+	default:
+		return ErrUnknownFormat
+	}
+	if err != nil {
+		return err
+	}
+	// Both headers end with their empty list, items are written between it and the closing brackets
+	if _, err := w.Write(header[:len(header)-2]); err != nil {
+		return err
+	}
+	sep := []byte{}
+	item := func(v interface{}) error {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(sep); err != nil {
+			return err
+		}
+		sep = []byte(",")
+		_, err = w.Write(b)
+		return err
+	}
+	err = d.ForEachChunk(world, func(cx int64, cy int64, data []byte) error {
+		if format == FormatJSON {
+			return item(jsonChunk{CX: cx, CY: cy, Tiles: data})
+		}
+		for i, v := range data {
+			if v == 0 {
+				continue
+			}
+			x := float64(cx*db.ChunkSize + int64(i%db.ChunkSize))
+			y := float64(cy*db.ChunkSize + int64(i/db.ChunkSize))
+			err := item(geoJSONFeature{
+				Type: "Feature",
+				Geometry: geoJSONGeometry{
+					Type:        "Polygon",
+					Coordinates: [][][2]float64{{{x, y}, {x + 1, y}, {x + 1, y + 1}, {x, y + 1}, {x, y}}},
+				},
+				Properties: map[string]interface{}{"value": v},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte("]}"))
+	return err
+}
+
+// Import replaces all of a world's tiles with data in the given format
+func Import(d db.Database, world []byte, format string, data []byte) error {
+	chunks := make(map[[2]int64][]byte)
+	switch format {
+	case FormatJSON:
+		var w jsonWorld
+		if err := json.Unmarshal(data, &w); err != nil {
+			return fmt.Errorf("%s: %s", ErrInvalidImport, err)
+		}
+		if w.ChunkSize != db.ChunkSize {
+			return fmt.Errorf("%s: chunk size must be %d", ErrInvalidImport, db.ChunkSize)
+		}
+		for _, c := range w.Chunks {
+			if len(c.Tiles) != db.ChunkLength {
+				return fmt.Errorf("%s: chunk %d %d must have %d tiles", ErrInvalidImport, c.CX, c.CY, db.ChunkLength)
+			}
+			chunks[[2]int64{c.CX, c.CY}] = c.Tiles
+		}
+	case FormatGeoJSON:
+		var c geoJSONCollection
+		if err := json.Unmarshal(data, &c); err != nil {
+			return fmt.Errorf("%s: %s", ErrInvalidImport, err)
+		}
+		// An export has a feature per tile, so allow a chunk per feature beyond what a fill may touch
+		maxChunks := db.MaxFillChunks
+		if len(c.Features) > maxChunks {
+			maxChunks = len(c.Features)
+		}
+		total := uint64(0)
+		for _, f := range c.Features {
+			x1, y1, x2, y2, ok := bounds(f.Geometry)
+			if !ok {
+				return fmt.Errorf("%s: features must be polygons", ErrInvalidImport)
+			}
+			v, ok := f.Properties["value"].(float64)
+			if !ok || v < 0 || v > 255 || v != math.Trunc(v) {
+				return fmt.Errorf("%s: features need a tile value between 0 and 255", ErrInvalidImport)
+			}
+			if x2 < x1 || y2 < y1 {
+				// Too thin to cover any tile's centre
+				continue
+			}
+			// The polygon's bounding box bounds the work, like a fill of the same size
+			dx, dy := uint64(x2)-uint64(x1), uint64(y2)-uint64(y1)
+			if dx >= db.MaxFillTiles || dy >= db.MaxFillTiles {
+				return db.ErrFillTooLarge
+			}
+			total += (dx + 1) * (dy + 1)
+			if total > db.MaxFillTiles {
+				return db.ErrFillTooLarge
+			}
+			full := false
+			rasterise(f.Geometry.Coordinates, y1, y2, func(x int64, y int64) bool {
+				cx, cy := db.ChunkOf(x, y)
+				key := [2]int64{cx, cy}
+				tiles, ok := chunks[key]
+				if !ok {
+					if len(chunks) >= maxChunks {
+						full = true
+						return false
+					}
+					tiles = make([]byte, db.ChunkLength)
+					chunks[key] = tiles
+				}
+				tiles[(y&(db.ChunkSize-1))*db.ChunkSize+(x&(db.ChunkSize-1))] = byte(v)
+				return true
+			})
+			if full {
+				return db.ErrFillTooLarge
+			}
+		}
+	default:
+		return ErrUnknownFormat
+	}
+	return d.ReplaceTiles(world, chunks)
+}
+
+// bounds returns the inclusive range of tiles whose centres may be inside a polygon, it's
+// empty when x2 < x1 or y2 < y1. Holes count too, a hole outside the outer ring fills by
+// the even-odd rule.
+func bounds(g geoJSONGeometry) (int64, int64, int64, int64, bool) {
+	if g.Type != "Polygon" || len(g.Coordinates) == 0 || len(g.Coordinates[0]) == 0 {
+		return 0, 0, 0, 0, false
+	}
+	const limit = 1 << 62
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, ring := range g.Coordinates {
+		for _, p := range ring {
+			if math.Abs(p[0]) > limit || math.Abs(p[1]) > limit {
+				return 0, 0, 0, 0, false
+			}
+			minX, maxX = math.Min(minX, p[0]), math.Max(maxX, p[0])
+			minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+		}
+	}
+	x1, y1 := int64(math.Floor(minX)), int64(math.Floor(minY))
+	x2, y2 := int64(math.Ceil(maxX))-1, int64(math.Ceil(maxY))-1
+	return x1, y1, x2, y2, true
+}
+
+type edge struct {
+	ax, ay, bx, by float64
+}
+
+// rasterise calls fn for every tile in rows y1 to y2 whose centre is inside the polygon
+// until fn returns false. Rings follow the even-odd rule, so holes are left empty.
+func rasterise(rings [][][2]float64, y1 int64, y2 int64, fn func(x int64, y int64) bool) {
+	edges := []edge{}
+	for _, ring := range rings {
+		for i := range ring {
+			a, b := ring[i], ring[(i+1)%len(ring)]
+			if a[1] == b[1] {
+				continue
+			}
+			if a[1] > b[1] {
+				a, b = b, a
+			}
+			edges = append(edges, edge{ax: a[0], ay: a[1], bx: b[0], by: b[1]})
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool { return edges[i].ay < edges[j].ay })
+
+	// Scan each row's centre line, keeping only the edges that cross it
+	active := []edge{}
+	xs := []float64{}
+	next := 0
+	for y := y1; y <= y2; y++ {
+		cy := float64(y) + 0.5
+		for next < len(edges) && edges[next].ay <= cy {
+			active = append(active, edges[next])
+			next++
+		}
+		xs = xs[:0]
+		kept := active[:0]
+		for _, e := range active {
+			if e.by <= cy {
+				continue
+			}
+			kept = append(kept, e)
+			xs = append(xs, e.ax+(cy-e.ay)*(e.bx-e.ax)/(e.by-e.ay))
+		}
+		active = kept
+		sort.Float64s(xs)
+		for i := 0; i+1 < len(xs); i += 2 {
+			for x := int64(math.Ceil(xs[i] - 0.5)); float64(x)+0.5 < xs[i+1]; x++ {
+				if !fn(x, y) {
+					return
+				}
+			}
+		}
+	}
+}
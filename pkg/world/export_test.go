@@ -0,0 +1,173 @@
+package world_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/furui/gochunk/pkg/db"
+	respTypes "github.com/furui/gochunk/pkg/types"
+	"github.com/furui/gochunk/pkg/world"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImport(t *testing.T) {
+	proc, client, cleanup := setupWorld(t)
+	defer cleanup()
+
+	_, err := proc.Execute("WSETTILE", client, params("w", "1", "2", "7"))
+	assert.NoError(t, err)
+	_, err = proc.Execute("WSETTILE", client, params("w", "-40", "3", "4"))
+	assert.NoError(t, err)
+
+	res, err := proc.Execute("WEXPORT", client, params("w"))
+	assert.NoError(t, err)
+	exported := res.(*respTypes.BulkString).Data
+	var w struct {
+		World     string
+		ChunkSize int
+		Chunks    []struct {
+			CX    int64
+			CY    int64
+			Tiles []byte
+		}
+	}
+	assert.NoError(t, json.Unmarshal(exported, &w))
+	assert.Equal(t, "w", w.World)
+	assert.Len(t, w.Chunks, 2)
+	assert.Equal(t, int64(-2), w.Chunks[0].CX)
+
+	res, err = proc.Execute("WIMPORT", client, params("copy", "FORMAT", "json", string(exported)))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("+OK\r\n"), res.Bytes())
+	res, err = proc.Execute("WGETTILE", client, params("copy", "-40", "3"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(":4\r\n"), res.Bytes())
+
+	res, err = proc.Execute("WEXPORT", client, params("w", "format", "GEOJSON"))
+	assert.NoError(t, err)
+	var geo struct {
+		Type     string
+		Features []struct {
+			Geometry struct {
+				Type        string
+				Coordinates [][][2]float64
+			}
+			Properties map[string]float64
+		}
+	}
+	assert.NoError(t, json.Unmarshal(res.(*respTypes.BulkString).Data, &geo))
+	assert.Equal(t, "FeatureCollection", geo.Type)
+	assert.Len(t, geo.Features, 2)
+	assert.Equal(t, [2]float64{-40, 3}, geo.Features[0].Geometry.Coordinates[0][0])
+	assert.Equal(t, float64(4), geo.Features[0].Properties["value"])
+
+	// Importing replaces the world and polygons fill the tiles whose centres they cover
+	rect := `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Polygon",` +
+		`"coordinates":[[[30,0],[34,0],[34,2],[30,2],[30,0]]]},"properties":{"value":5}}]}`
+	_, err = proc.Execute("WIMPORT", client, params("w", "FORMAT", "geojson", rect))
+	assert.NoError(t, err)
+	res, err = proc.Execute("WGETTILE", client, params("w", "33", "1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(":5\r\n"), res.Bytes())
+	res, err = proc.Execute("WGETTILE", client, params("w", "34", "2"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(":0\r\n"), res.Bytes())
+	res, err = proc.Execute("WGETTILE", client, params("w", "1", "2"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(":0\r\n"), res.Bytes())
+
+	// Exported tiles import back unchanged
+	_, err = proc.Execute("WFILL", client, params("w", "-3", "-3", "3", "40", "9"))
+	assert.NoError(t, err)
+	res, err = proc.Execute("WEXPORT", client, params("w", "FORMAT", "geojson"))
+	assert.NoError(t, err)
+	_, err = proc.Execute("WIMPORT", client, params("copy", "FORMAT", "geojson", string(res.(*respTypes.BulkString).Data)))
+	assert.NoError(t, err)
+	want, err := proc.Execute("WEXPORT", client, params("w"))
+	assert.NoError(t, err)
+	res, err = proc.Execute("WEXPORT", client, params("copy"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(want.(*respTypes.BulkString).Data), strings.Replace(string(res.(*respTypes.BulkString).Data), `"copy"`, `"w"`, 1))
+
+	_, err = proc.Execute("WEXPORT", client, params("w", "FORMAT", "xml"))
+	assert.Error(t, err)
+	_, err = proc.Execute("WIMPORT", client, params("w", "{"))
+	assert.Error(t, err)
+	_, err = proc.Execute("WIMPORT", client, params("w", `{"chunkSize":32,"chunks":[{"cx":0,"cy":0,"tiles":"AA=="}]}`))
+	assert.Error(t, err)
+}
+
+func TestImportPolygons(t *testing.T) {
+	proc, client, cleanup := setupWorld(t)
+	defer cleanup()
+
+	testCases := []struct {
+		desc    string
+		rings   string
+		want    []string
+		wantOff []string
+		err     error
+	}{
+		{
+			desc:    "L shape",
+			rings:   `[[[0,0],[4,0],[4,1],[1,1],[1,4],[0,4],[0,0]]]`,
+			want:    []string{"0 0", "3 0", "0 3"},
+			wantOff: []string{"1 1", "3 3", "4 0", "0 4"},
+		},
+		{
+			desc:    "diagonal",
+			rings:   `[[[0,0],[4,0],[0,4],[0,0]]]`,
+			want:    []string{"0 0", "2 0", "0 2", "1 1"},
+			wantOff: []string{"3 0", "0 3", "1 2", "2 1", "3 3"},
+		},
+		{
+			desc:    "hole",
+			rings:   `[[[0,0],[3,0],[3,3],[0,3],[0,0]],[[1,1],[2,1],[2,2],[1,2],[1,1]]]`,
+			want:    []string{"0 0", "1 0", "2 2", "0 1"},
+			wantOff: []string{"1 1", "3 3"},
+		},
+		{
+			desc:  "wider than a fill",
+			rings: `[[[-4611686018427387904,0],[4611686018427387904,0],[4611686018427387904,1],[-4611686018427387904,1],[-4611686018427387904,0]]]`,
+			err:   db.ErrFillTooLarge,
+		},
+		{
+			desc:  "hole outside the outer ring",
+			rings: `[[[0,0],[1,0],[1,1],[0,1],[0,0]],[[-4611686018427387904,0],[-4611686018427387903,0],[-4611686018427387903,1],[-4611686018427387904,1],[-4611686018427387904,0]]]`,
+			err:   db.ErrFillTooLarge,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			data := `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Polygon",` +
+				`"coordinates":` + tC.rings + `},"properties":{"value":5}}]}`
+			_, err := proc.Execute("WIMPORT", client, params("w", "FORMAT", "geojson", data))
+			assert.Equal(t, tC.err, err)
+			for _, p := range tC.want {
+				xy := strings.Split(p, " ")
+				res, err := proc.Execute("WGETTILE", client, params("w", xy[0], xy[1]))
+				assert.NoError(t, err)
+				assert.Equal(t, []byte(":5\r\n"), res.Bytes(), p)
+			}
+			for _, p := range tC.wantOff {
+				xy := strings.Split(p, " ")
+				res, err := proc.Execute("WGETTILE", client, params("w", xy[0], xy[1]))
+				assert.NoError(t, err)
+				assert.Equal(t, []byte(":0\r\n"), res.Bytes(), p)
+			}
+		})
+	}
+}
+
+func TestExportTooLarge(t *testing.T) {
+	proc, client, cleanup := setupWorld(t)
+	defer cleanup()
+
+	_, err := proc.Execute("WFILL", client, params("w", "0", "0", "1023", "511", "1"))
+	assert.NoError(t, err)
+	_, err = proc.Execute("WEXPORT", client, params("w"))
+	assert.NoError(t, err)
+	_, err = proc.Execute("WEXPORT", client, params("w", "FORMAT", "geojson"))
+	assert.Equal(t, world.ErrExportTooLarge, err)
+}
@@ -0,0 +1,220 @@
+package world
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+
+	"github.com/furui/gochunk/pkg/db"
+)
+
+const (
+	// DefaultMaxNodes is the number of tiles a path search expands before giving up
+	DefaultMaxNodes = 10000
+
+	straightCost = 10
+	diagonalCost = 14
+)
+
+var (
+	// ErrSearchLimit is thrown when a path search expands more than its maximum nodes
+	ErrSearchLimit = errors.New("path search exceeded node limit")
+)
+
+type point struct {
+	x int64
+	y int64
+}
+
+type step struct {
+	dx   int64
+	dy   int64
+	cost int64
+}
+
+var (
+	straightSteps = []step{{1, 0, straightCost}, {-1, 0, straightCost}, {0, 1, straightCost}, {0, -1, straightCost}}
+	diagonalSteps = []step{{1, 1, diagonalCost}, {1, -1, diagonalCost}, {-1, 1, diagonalCost}, {-1, -1, diagonalCost}}
+)
+
+// tileCache reads whole chunks from the database once per search
+type tileCache struct {
+	d      db.Database
+	world  []byte
+	chunks map[point][]byte
+}
+
+// cost of entering a tile, zero if the tile is blocked or its chunk was never written
+func (c *tileCache) cost(p point) (int64, error) {
+	cx, cy := db.ChunkOf(p.x, p.y)
+	key := point{cx, cy}
+	data, ok := c.chunks[key]
+	if !ok {
+		var err error
+		data, err = c.d.TileChunk(c.world, cx, cy)
+		if err != nil {
+			return 0, err
+		}
+		c.chunks[key] = data
+	}
+	if data == nil {
+		return 0, nil
+	}
+	return int64(data[(p.y&(db.ChunkSize-1))*db.ChunkSize+(p.x&(db.ChunkSize-1))]), nil
+}
+
+type node struct {
+	p     point
+	f     int64
+	index int
+}
+
+type openSet []*node
+
+func (o openSet) Len() int { return len(o) }
+
+func (o openSet) Less(i, j int) bool { return o[i].f < o[j].f }
+
+func (o openSet) Swap(i, j int) {
+	o[i], o[j] = o[j], o[i]
+	o[i].index = i
+	o[j].index = j
+}
+
+func (o *openSet) Push(x interface{}) {
+	n := x.(*node)
+	n.index = len(*o)
+	*o = append(*o, n)
+}
+
+func (o *openSet) Pop() interface{} {
+	old := *o
+	n := old[len(old)-1]
+	*o = old[:len(old)-1]
+	n.index = -1
+	return n
+}
+
+// distance returns |a-b| in uint64 so coordinates far apart don't wrap
+func distance(a int64, b int64) uint64 {
+	if a > b {
+		return uint64(a) - uint64(b)
+	}
+	return uint64(b) - uint64(a)
+}
+
+func heuristic(a point, b point, diagonal bool) int64 {
+	// Capping the distances keeps the estimate from overflowing, a lower estimate still finds the cheapest path
+	const limit = math.MaxInt64 / (4 * diagonalCost)
+	dx, dy := distance(a.x, b.x), distance(a.y, b.y)
+	if dx > limit {
+		dx = limit
+	}
+	if dy > limit {
+		dy = limit
+	}
+	if !diagonal {
+		return straightCost * int64(dx+dy)
+	}
+	if dx < dy {
+		dx, dy = dy, dx
+	}
+	return straightCost*int64(dx-dy) + diagonalCost*int64(dy)
+}
+
+func offset(p point, s step) (point, bool) {
+	if (s.dx > 0 && p.x == math.MaxInt64) || (s.dx < 0 && p.x == math.MinInt64) {
+		return p, false
+	}
+	if (s.dy > 0 && p.y == math.MaxInt64) || (s.dy < 0 && p.y == math.MinInt64) {
+		return p, false
+	}
+	return point{p.x + s.dx, p.y + s.dy}, true
+}
+
+// findPath runs A* from start to goal where each tile's value is the cost of entering it
+// and zero blocks movement. A nil path is returned when the goal is unreachable.
+func findPath(tiles *tileCache, start point, goal point, diagonal bool, maxNodes int) ([]point, error) {
+	if c, err := tiles.cost(goal); err != nil || c == 0 {
+		return nil, err
+	}
+	if c, err := tiles.cost(start); err != nil || c == 0 {
+		return nil, err
+	}
+	steps := straightSteps
+	if diagonal {
+		steps = append(append([]step{}, straightSteps...), diagonalSteps...)
+	}
+	g := map[point]int64{start: 0}
+	from := map[point]point{}
+	open := map[point]*node{}
+	closed := map[point]bool{}
+	queue := &openSet{}
+	n := &node{p: start, f: heuristic(start, goal, diagonal)}
+	heap.Push(queue, n)
+	open[start] = n
+	expanded := 0
+	for queue.Len() > 0 {
+		current := heap.Pop(queue).(*node)
+		delete(open, current.p)
+		if current.p == goal {
+			path := []point{goal}
+			for p := goal; p != start; {
+				p = from[p]
+				path = append(path, p)
+			}
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return path, nil
+		}
+		closed[current.p] = true
+		expanded++
+		if expanded > maxNodes {
+			return nil, ErrSearchLimit
+		}
+		for _, s := range steps {
+			next, ok := offset(current.p, s)
+			if !ok || closed[next] {
+				continue
+			}
+			c, err := tiles.cost(next)
+			if err != nil {
+				return nil, err
+			}
+			if c == 0 {
+				continue
+			}
+			if s.dx != 0 && s.dy != 0 {
+				// Don't cut corners past blocked tiles
+				a, err := tiles.cost(point{current.p.x + s.dx, current.p.y})
+				if err != nil {
+					return nil, err
+				}
+				b, err := tiles.cost(point{current.p.x, current.p.y + s.dy})
+				if err != nil {
+					return nil, err
+				}
+				if a == 0 || b == 0 {
+					continue
+				}
+			}
+			score := g[current.p] + c*s.cost
+			if old, ok := g[next]; ok && score >= old {
+				continue
+			}
+			g[next] = score
+			from[next] = current.p
+			f := score + heuristic(next, goal, diagonal)
+			if n, ok := open[next]; ok {
+				n.f = f
+				heap.Fix(queue, n.index)
+				continue
+			}
+			n := &node{p: next, f: f}
+			heap.Push(queue, n)
+			open[next] = n
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,109 @@
+package world_test
+
+import (
+	"testing"
+
+	respTypes "github.com/furui/gochunk/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func pathPoints(t *testing.T, res respTypes.Type) [][2]int64 {
+	arr, ok := res.(*respTypes.Array)
+	if !ok {
+		t.Fatalf("expected array, got %T", res)
+	}
+	points := [][2]int64{}
+	for _, v := range arr.Contents {
+		p := v.(*respTypes.Array).Contents
+		points = append(points, [2]int64{p[0].Value().(int64), p[1].Value().(int64)})
+	}
+	return points
+}
+
+func TestPath(t *testing.T) {
+	proc, client, cleanup := setupWorld(t)
+	defer cleanup()
+
+	// Open ground across two chunks with a wall at x=31 that has a gap at y=5
+	_, err := proc.Execute("WFILL", client, params("w", "0", "0", "63", "9", "1"))
+	assert.NoError(t, err)
+	_, err = proc.Execute("WFILL", client, params("w", "31", "0", "31", "9", "0"))
+	assert.NoError(t, err)
+	_, err = proc.Execute("WSETTILE", client, params("w", "31", "5", "1"))
+	assert.NoError(t, err)
+	// Expensive swamp next to the direct route
+	_, err = proc.Execute("WFILL", client, params("w", "2", "1", "2", "3", "50"))
+	assert.NoError(t, err)
+
+	t.Run("same tile", func(t *testing.T) {
+		res, err := proc.Execute("WPATH", client, params("w", "1", "1", "1", "1"))
+		assert.NoError(t, err)
+		assert.Equal(t, [][2]int64{{1, 1}}, pathPoints(t, res))
+	})
+
+	t.Run("through gap", func(t *testing.T) {
+		res, err := proc.Execute("WPATH", client, params("w", "30", "0", "32", "0"))
+		assert.NoError(t, err)
+		path := pathPoints(t, res)
+		assert.Equal(t, [2]int64{30, 0}, path[0])
+		assert.Equal(t, [2]int64{32, 0}, path[len(path)-1])
+		assert.Contains(t, path, [2]int64{31, 5})
+		assert.Len(t, path, 13)
+	})
+
+	t.Run("avoids expensive tiles", func(t *testing.T) {
+		res, err := proc.Execute("WPATH", client, params("w", "1", "2", "3", "2"))
+		assert.NoError(t, err)
+		path := pathPoints(t, res)
+		assert.NotContains(t, path, [2]int64{2, 2})
+		assert.Len(t, path, 7)
+	})
+
+	t.Run("diagonal", func(t *testing.T) {
+		res, err := proc.Execute("WPATH", client, params("w", "5", "5", "9", "9", "diagonal"))
+		assert.NoError(t, err)
+		assert.Len(t, pathPoints(t, res), 5)
+	})
+
+	t.Run("blocked goal", func(t *testing.T) {
+		res, err := proc.Execute("WPATH", client, params("w", "0", "0", "31", "0"))
+		assert.NoError(t, err)
+		assert.Empty(t, pathPoints(t, res))
+	})
+
+	t.Run("blocked start", func(t *testing.T) {
+		res, err := proc.Execute("WPATH", client, params("w", "31", "0", "0", "0"))
+		assert.NoError(t, err)
+		assert.Empty(t, pathPoints(t, res))
+	})
+
+	t.Run("far apart", func(t *testing.T) {
+		_, err := proc.Execute("WSETTILE", client, params("w", "-9223372036854775808", "0", "1"))
+		assert.NoError(t, err)
+		_, err = proc.Execute("WSETTILE", client, params("w", "9223372036854775807", "0", "1"))
+		assert.NoError(t, err)
+		res, err := proc.Execute("WPATH", client, params("w", "-9223372036854775808", "0", "9223372036854775807", "0"))
+		assert.NoError(t, err)
+		assert.Empty(t, pathPoints(t, res))
+	})
+
+	t.Run("unwritten chunk", func(t *testing.T) {
+		res, err := proc.Execute("WPATH", client, params("w", "0", "0", "100", "0"))
+		assert.NoError(t, err)
+		assert.Empty(t, pathPoints(t, res))
+	})
+
+	t.Run("node limit", func(t *testing.T) {
+		_, err := proc.Execute("WPATH", client, params("w", "0", "0", "63", "9", "MAXNODES", "10"))
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := proc.Execute("WPATH", client, params("w", "0", "0", "1", "1", "MAXNODES"))
+		assert.Error(t, err)
+		_, err = proc.Execute("WPATH", client, params("w", "0", "0", "1", "1", "FAST"))
+		assert.Error(t, err)
+		_, err = proc.Execute("WPATH", client, params("w", "0", "0", "1", "1", "MAXNODES", "2000000000"))
+		assert.EqualError(t, err, "MAXNODES can't be more than 100000")
+	})
+}
@@ -0,0 +1,48 @@
+package world
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/furui/gochunk/pkg/config"
+	"github.com/furui/gochunk/pkg/processor"
+)
+
+var (
+	// ErrInvalidCoordinate is thrown when a coordinate isn't an integer
+	ErrInvalidCoordinate = errors.New("invalid coordinate")
+	// ErrInvalidTile is thrown when a tile value isn't between 0 and 255
+	ErrInvalidTile = errors.New("invalid tile value")
+)
+
+// Register adds the world commands to the processor
+func Register(config *config.Config, processor processor.Processor) {
+	addSetTileCmd(config, processor)
+	addGetTileCmd(config, processor)
+	addFillCmd(config, processor)
+	addChunkCmd(config, processor)
+	addChunksCmd(config, processor)
+	addInfoCmd(config, processor)
+	addPathCmd(config, processor)
+	addSnapshotCmd(config, processor)
+	addSnapshotsCmd(config, processor)
+	addRollbackCmd(config, processor)
+	addExportCmd(config, processor)
+	addImportCmd(config, processor)
+}
+
+func parseCoordinate(b []byte) (int64, error) {
+	i, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidCoordinate
+	}
+	return i, nil
+}
+
+func parseTile(b []byte) (byte, error) {
+	i, err := strconv.ParseUint(string(b), 10, 8)
+	if err != nil {
+		return 0, ErrInvalidTile
+	}
+	return byte(i), nil
+}
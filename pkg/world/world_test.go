@@ -0,0 +1,224 @@
+package world_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/furui/gochunk/pkg/config"
+	"github.com/furui/gochunk/pkg/db"
+	"github.com/furui/gochunk/pkg/processor"
+	"github.com/furui/gochunk/pkg/state"
+	respTypes "github.com/furui/gochunk/pkg/types"
+	"github.com/furui/gochunk/pkg/uuid"
+	"github.com/furui/gochunk/pkg/world"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupWorld(t *testing.T) (processor.Processor, state.Client, func()) {
+	dir, err := ioutil.TempDir("", "gochunk-world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := config.NewConfig()
+	conf.DatabaseLocation = dir
+	data := db.NewManager(conf, uuid.NewGenerator())
+	proc := processor.NewProcessor(data)
+	world.Register(conf, proc)
+	client := state.NewClient()
+	client.SetAuthRequired("")
+	return proc, client, func() {
+		data.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func params(args ...string) [][]byte {
+	p := [][]byte{}
+	for _, a := range args {
+		p = append(p, []byte(a))
+	}
+	return p
+}
+
+func TestTiles(t *testing.T) {
+	proc, client, cleanup := setupWorld(t)
+	defer cleanup()
+
+	testCases := []struct {
+		desc    string
+		command string
+		params  [][]byte
+		want    []byte
+		wantErr bool
+	}{
+		{
+			desc:    "get unwritten tile",
+			command: "WGETTILE",
+			params:  params("w", "3", "4"),
+			want:    []byte(":0\r\n"),
+		},
+		{
+			desc:    "unwritten chunk",
+			command: "WCHUNK",
+			params:  params("w", "0", "0"),
+			want:    []byte("$-1\r\n"),
+		},
+		{
+			desc:    "set tile",
+			command: "WSETTILE",
+			params:  params("w", "3", "4", "7"),
+			want:    []byte("+OK\r\n"),
+		},
+		{
+			desc:    "get tile",
+			command: "WGETTILE",
+			params:  params("w", "3", "4"),
+			want:    []byte(":7\r\n"),
+		},
+		{
+			desc:    "other world untouched",
+			command: "WGETTILE",
+			params:  params("v", "3", "4"),
+			want:    []byte(":0\r\n"),
+		},
+		{
+			desc:    "negative coordinates",
+			command: "WSETTILE",
+			params:  params("w", "-1", "-33", "9"),
+			want:    []byte("+OK\r\n"),
+		},
+		{
+			desc:    "get negative coordinates",
+			command: "WGETTILE",
+			params:  params("w", "-1", "-33"),
+			want:    []byte(":9\r\n"),
+		},
+		{
+			desc:    "fill across chunks",
+			command: "WFILL",
+			params:  params("w", "40", "10", "30", "12", "2"),
+			want:    []byte(":33\r\n"),
+		},
+		{
+			desc:    "get filled tile",
+			command: "WGETTILE",
+			params:  params("w", "32", "11"),
+			want:    []byte(":2\r\n"),
+		},
+		{
+			desc:    "fill too large",
+			command: "WFILL",
+			params:  params("w", "-9223372036854775808", "0", "9223372036854775807", "0", "1"),
+			wantErr: true,
+		},
+		{
+			desc:    "invalid tile value",
+			command: "WSETTILE",
+			params:  params("w", "0", "0", "256"),
+			wantErr: true,
+		},
+		{
+			desc:    "invalid coordinate",
+			command: "WGETTILE",
+			params:  params("w", "a", "0"),
+			wantErr: true,
+		},
+		{
+			desc:    "wrong parameter count",
+			command: "WCHUNK",
+			params:  params("w", "0"),
+			wantErr: true,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			res, err := proc.Execute(tC.command, client, tC.params)
+			if tC.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tC.want, res.Bytes())
+		})
+	}
+}
+
+func TestChunk(t *testing.T) {
+	proc, client, cleanup := setupWorld(t)
+	defer cleanup()
+
+	_, err := proc.Execute("WSETTILE", client, params("w", "33", "34", "5"))
+	assert.NoError(t, err)
+	res, err := proc.Execute("WCHUNK", client, params("w", "1", "1"))
+	assert.NoError(t, err)
+	data, ok := res.(*respTypes.BulkString)
+	assert.True(t, ok)
+	assert.Len(t, data.Data, db.ChunkLength)
+	assert.Equal(t, byte(5), data.Data[2*db.ChunkSize+1])
+	data.Data[0] = 1
+	res, err = proc.Execute("WGETTILE", client, params("w", "32", "32"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(":0\r\n"), res.Bytes())
+	res, err = proc.Execute("WCHUNKS", client, params("w"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("*1\r\n*2\r\n:1\r\n:1\r\n"), res.Bytes())
+	res, err = proc.Execute("WINFO", client, params("w"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("*6\r\n$6\r\nchunks\r\n:1\r\n$15\r\nresident_chunks\r\n:1\r\n$12\r\nmemory_bytes\r\n:1024\r\n"), res.Bytes())
+	res, err = proc.Execute("WINFO", client, params("missing"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("*6\r\n$6\r\nchunks\r\n:0\r\n$15\r\nresident_chunks\r\n:0\r\n$12\r\nmemory_bytes\r\n:0\r\n"), res.Bytes())
+}
+
+func TestSnapshot(t *testing.T) {
+	proc, client, cleanup := setupWorld(t)
+	defer cleanup()
+
+	_, err := proc.Execute("WFILL", client, params("w", "0", "0", "40", "1", "3"))
+	assert.NoError(t, err)
+	res, err := proc.Execute("WSNAPSHOT", client, params("w", "start"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("+OK\r\n"), res.Bytes())
+
+	_, err = proc.Execute("WSETTILE", client, params("w", "1", "1", "9"))
+	assert.NoError(t, err)
+	_, err = proc.Execute("WSETTILE", client, params("w", "100", "100", "9"))
+	assert.NoError(t, err)
+
+	res, err = proc.Execute("WROLLBACK", client, params("w", "start"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("+OK\r\n"), res.Bytes())
+	res, err = proc.Execute("WGETTILE", client, params("w", "1", "1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(":3\r\n"), res.Bytes())
+	res, err = proc.Execute("WCHUNK", client, params("w", "3", "3"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("$-1\r\n"), res.Bytes())
+
+	_, err = proc.Execute("WROLLBACK", client, params("w", "missing"))
+	assert.Error(t, err)
+	_, err = proc.Execute("WROLLBACK", client, params("v", "start"))
+	assert.Error(t, err)
+
+	// Snapshots are listed by name until they're deleted
+	_, err = proc.Execute("WSNAPSHOT", client, params("w", "later"))
+	assert.NoError(t, err)
+	res, err = proc.Execute("WSNAPSHOTS", client, params("w"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("*2\r\n$5\r\nlater\r\n$5\r\nstart\r\n"), res.Bytes())
+	res, err = proc.Execute("WSNAPSHOT", client, params("w", "start", "del"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("+OK\r\n"), res.Bytes())
+	_, err = proc.Execute("WROLLBACK", client, params("w", "start"))
+	assert.Error(t, err)
+	_, err = proc.Execute("WSNAPSHOT", client, params("w", "start", "DEL"))
+	assert.Error(t, err)
+	_, err = proc.Execute("WSNAPSHOT", client, params("w", "later", "DEL"))
+	assert.NoError(t, err)
+	res, err = proc.Execute("WSNAPSHOTS", client, params("w"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("*0\r\n"), res.Bytes())
+	_, err = proc.Execute("WSNAPSHOT", client, params("w", "start", "DROP"))
+	assert.Error(t, err)
+}